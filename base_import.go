@@ -0,0 +1,473 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hexya-addons/web/odooproxy"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/operator"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// importPreviewLimit is the default number of rows returned by ParsePreview
+const importPreviewLimit = 10
+
+// importSkippedFields are the fields that are never imported directly
+var importSkippedFields = map[string]bool{
+	"id":                true,
+	"hexya_external_id": true,
+	"hexya_version":     true,
+	"create_date":       true,
+	"write_date":        true,
+	"display_name":      true,
+	"__last_update":     true,
+}
+
+var fields_ImportWizard = map[string]models.FieldDefinition{
+	"ResModel": fields.Char{String: "Model", Required: true, JSON: "res_model"},
+	"File":     fields.Binary{String: "File", Help: "File to import, in CSV or XLSX format"},
+	"FileName": fields.Char{String: "File Name"},
+	"FileType": fields.Char{String: "File Type"},
+}
+
+// ImportFields returns the fields of the model to import into that can be
+// set from a file column, with their importable sub fields.
+//
+// Relation fields can be imported by name, or through their 'id' (external ID)
+// and '.id' (database ID) sub fields. One2many fields cannot be imported.
+func importWizard_ImportFields(rs m.ImportWizardSet) []webtypes.ImportField {
+	rs.EnsureOne()
+	model := rs.Env().Pool(odooproxy.ConvertModelName(rs.ResModel()))
+	fInfos := model.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+	res := []webtypes.ImportField{{ID: "id", Name: "id", String: "External ID"}}
+	for fName, fInfo := range fInfos {
+		if importSkippedFields[fName] || fInfo.ReadOnly || fInfo.Type == fieldtype.One2Many {
+			continue
+		}
+		field := webtypes.ImportField{
+			ID:       fName,
+			Name:     fName,
+			String:   fInfo.String,
+			Required: fInfo.Required,
+			Type:     fInfo.Type,
+		}
+		if fInfo.Relation != "" {
+			field.Fields = []webtypes.ImportField{
+				{ID: fName + "/id", Name: "id", String: "External ID", Type: fieldtype.Char},
+				{ID: fName + "/.id", Name: ".id", String: "Database ID", Type: fieldtype.Integer},
+			}
+		}
+		res = append(res, field)
+	}
+	sort.Slice(res[1:], func(i, j int) bool {
+		return res[i+1].String < res[j+1].String
+	})
+	return res
+}
+
+// ParsePreview parses the file of this wizard with the given options and returns
+// its first rows, together with the fields matching each column header.
+func importWizard_ParsePreview(rs m.ImportWizardSet, options webtypes.ImportOptions) *webtypes.ImportPreview {
+	rows, err := rs.ReadFile(options)
+	if err != nil {
+		log.Panic(err.Error(), "file", rs.FileName())
+	}
+	if options.Limit == 0 {
+		options.Limit = importPreviewLimit
+	}
+	res := &webtypes.ImportPreview{
+		Fields:  rs.ImportFields(),
+		Matches: make(map[int]string),
+		Options: options,
+	}
+	if options.Headers && len(rows) > 0 {
+		res.Headers = rows[0]
+		rows = rows[1:]
+		for i, header := range res.Headers {
+			if match := rs.MatchHeader(header, res.Fields); match != "" {
+				res.Matches[i] = match
+			}
+		}
+	}
+	if len(rows) > options.Limit {
+		rows = rows[:options.Limit]
+	}
+	res.Preview = rows
+	return res
+}
+
+// MatchHeader returns the path of the field matching the given column header or
+// an empty string if no field matches. Each part of the header, separated by
+// slashes, must match the JSON name, the label or the translated label of a field.
+func importWizard_MatchHeader(rs m.ImportWizardSet, header string, importFields []webtypes.ImportField) string {
+	rs.EnsureOne()
+	model := rs.Env().Pool(odooproxy.ConvertModelName(rs.ResModel()))
+	labels := make(map[string]string)
+	for fName, fInfo := range model.Model().FieldsGet() {
+		labels[fName] = fInfo.String
+	}
+	var path []string
+	candidates := importFields
+	for _, part := range strings.Split(header, "/") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		var match *webtypes.ImportField
+		for i, field := range candidates {
+			if part == strings.ToLower(field.Name) || part == strings.ToLower(field.String) ||
+				(len(path) == 0 && part == strings.ToLower(labels[field.Name])) {
+				match = &candidates[i]
+				break
+			}
+		}
+		if match == nil {
+			return ""
+		}
+		path = append(path, match.Name)
+		candidates = match.Fields
+	}
+	return strings.Join(path, "/")
+}
+
+// ReadFile returns the rows of the file of this wizard, parsed with the given options.
+//
+// It panics if this wizard has not been created by the current user.
+func importWizard_ReadFile(rs m.ImportWizardSet, options webtypes.ImportOptions) ([][]string, error) {
+	rs.EnsureOne()
+	if rs.Env().Uid() != security.SuperUserID && rs.CreateUID() != rs.Env().Uid() {
+		log.Panic(rs.T("You can only use the import wizards you created"), "wizard", rs.ID(), "uid", rs.Env().Uid())
+	}
+	content, err := base64.StdEncoding.DecodeString(rs.File())
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode file: %s", err)
+	}
+	switch {
+	case rs.FileType() == "text/csv" || strings.ToLower(filepath.Ext(rs.FileName())) == ".csv":
+		return readImportCSV(content, options)
+	case rs.FileType() == "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" ||
+		strings.ToLower(filepath.Ext(rs.FileName())) == ".xlsx":
+		return readXLSX(content)
+	}
+	return nil, fmt.Errorf("unsupported file format '%s', import only supports CSV and XLSX files", rs.FileName())
+}
+
+// readImportCSV returns the rows of the given UTF-8 encoded CSV content
+func readImportCSV(content []byte, options webtypes.ImportOptions) ([][]string, error) {
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(content) {
+		return nil, errors.New("CSV file must be UTF-8 encoded")
+	}
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if options.Separator != "" {
+		sep, _ := utf8.DecodeRuneInString(options.Separator)
+		r.Comma = sep
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV file: %s", err)
+	}
+	return rows, nil
+}
+
+// Do imports the file of this wizard in its model, setting each column in the field given
+// at the same index in params.Fields. Columns with an empty field are not imported.
+//
+// Rows with an 'id' (external ID) or '.id' (database ID) column matching an existing record
+// update this record, other rows create new records. Empty cells are ignored.
+//
+// The import is done in the current transaction and is rolled back if any row fails, or
+// in all cases if params.DryRun is set. The returned messages give the errors of each row.
+func importWizard_Do(rs m.ImportWizardSet, params webtypes.ImportParams) *webtypes.ImportResult {
+	rows, err := rs.ReadFile(params.Options)
+	if err != nil {
+		return &webtypes.ImportResult{Messages: []webtypes.ImportMessage{{Type: "error", Message: err.Error()}}}
+	}
+	firstRow := 1
+	if params.Options.Headers && len(rows) > 0 {
+		rows = rows[1:]
+		firstRow = 2
+	}
+	rc := rs.Env().Pool(odooproxy.ConvertModelName(rs.ResModel()))
+	res := new(webtypes.ImportResult)
+	imp := newRecordImporter(rc, params.Fields, params.Options)
+	if imp.errors > 0 {
+		res.Messages = imp.messages
+		return res
+	}
+	cr := rs.Env().Cr()
+	cr.Execute("SAVEPOINT import")
+	for i, row := range rows {
+		if id := imp.importRow(row, firstRow+i); id != 0 {
+			res.IDs = append(res.IDs, id)
+		}
+	}
+	res.Messages = imp.messages
+	if imp.errors > 0 || params.DryRun {
+		cr.Execute("ROLLBACK TO SAVEPOINT import")
+		if imp.errors > 0 {
+			res.IDs = nil
+		}
+		return res
+	}
+	cr.Execute("RELEASE SAVEPOINT import")
+	return res
+}
+
+// A recordImporter imports rows of values into the records of a model
+type recordImporter struct {
+	rc       *models.RecordCollection
+	paths    [][]string
+	fInfos   map[string]*models.FieldInfo
+	options  webtypes.ImportOptions
+	messages []webtypes.ImportMessage
+	errors   int
+	row      int
+}
+
+// newRecordImporter returns a recordImporter for the given RecordCollection that imports
+// rows with the given field paths. Invalid fields are reported in the messages.
+func newRecordImporter(rc *models.RecordCollection, fieldPaths []string, options webtypes.ImportOptions) *recordImporter {
+	imp := &recordImporter{
+		rc:      rc,
+		paths:   make([][]string, len(fieldPaths)),
+		fInfos:  rc.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo),
+		options: options,
+	}
+	for i, fp := range fieldPaths {
+		if fp == "" {
+			continue
+		}
+		path := strings.Split(fp, "/")
+		imp.paths[i] = path
+		if path[0] == "id" || path[0] == ".id" {
+			continue
+		}
+		fInfo, ok := imp.fInfos[path[0]]
+		switch {
+		case !ok || importSkippedFields[path[0]]:
+			imp.addMessage(0, path[0], "Unknown field '%s'", path[0])
+		case fInfo.Type == fieldtype.One2Many:
+			imp.addMessage(0, path[0], "One2many field '%s' cannot be imported", fInfo.String)
+		case fInfo.ReadOnly:
+			// Computed fields without inverse method are also read only
+			imp.addMessage(0, path[0], "Read only field '%s' cannot be imported", fInfo.String)
+		case len(path) > 2 || (len(path) == 2 && (fInfo.Relation == "" || (path[1] != "id" && path[1] != ".id"))):
+			imp.addMessage(0, path[0], "Invalid field path '%s'", fp)
+		}
+	}
+	return imp
+}
+
+// addMessage adds an error message for the given row and field
+func (imp *recordImporter) addMessage(row int, field string, msg string, args ...interface{}) {
+	imp.errors++
+	imp.messages = append(imp.messages, webtypes.ImportMessage{
+		Type:    "error",
+		Message: fmt.Sprintf(msg, args...),
+		Row:     row,
+		Field:   field,
+	})
+}
+
+// addWarning adds a warning message for the current row and the given field.
+// Warnings do not prevent the import.
+func (imp *recordImporter) addWarning(field string, msg string, args ...interface{}) {
+	imp.messages = append(imp.messages, webtypes.ImportMessage{
+		Type:    "warning",
+		Message: fmt.Sprintf(msg, args...),
+		Row:     imp.row,
+		Field:   field,
+	})
+}
+
+// importRow creates or updates a record with the values of the given row and returns its ID.
+//
+// If the row cannot be imported, errors are added to the messages and 0 is returned.
+// Each row is imported in its own savepoint so that errors do not abort the transaction.
+func (imp *recordImporter) importRow(row []string, rowNum int) (id int64) {
+	imp.row = rowNum
+	record := imp.rc.Env().Pool(imp.rc.ModelName())
+	data := models.NewModelData(imp.rc.Model())
+	valid := true
+	for i, path := range imp.paths {
+		if path == nil || i >= len(row) || strings.TrimSpace(row[i]) == "" {
+			continue
+		}
+		value := strings.TrimSpace(row[i])
+		switch path[0] {
+		case "id":
+			record = imp.rc.Search(imp.rc.Model().Field(imp.rc.Model().FieldName("HexyaExternalID")).Equals(value))
+			if record.IsEmpty() {
+				data.Set(imp.rc.Model().FieldName("HexyaExternalID"), value)
+			}
+			continue
+		case ".id":
+			dbID, err := strconv.ParseInt(value, 10, 64)
+			record = imp.rc.Search(imp.rc.Model().Field(models.ID).Equals(dbID))
+			if err != nil || record.IsEmpty() {
+				imp.addMessage(rowNum, path[0], "No record found with database ID '%s'", value)
+				valid = false
+			}
+			continue
+		}
+		fInfo := imp.fInfos[path[0]]
+		subField := ""
+		if len(path) > 1 {
+			subField = path[1]
+		}
+		val, err := imp.convertValue(value, fInfo, subField)
+		if err != nil {
+			imp.addMessage(rowNum, path[0], "%s: %s", fInfo.String, err)
+			valid = false
+			continue
+		}
+		data.Set(imp.rc.Model().FieldName(path[0]), val)
+	}
+	if !valid {
+		return 0
+	}
+
+	cr := imp.rc.Env().Cr()
+	cr.Execute("SAVEPOINT import_row")
+	defer func() {
+		if r := recover(); r != nil {
+			cr.Execute("ROLLBACK TO SAVEPOINT import_row")
+			imp.addMessage(rowNum, "", "%v", r)
+			id = 0
+			return
+		}
+		cr.Execute("RELEASE SAVEPOINT import_row")
+	}()
+	if record.IsNotEmpty() {
+		record.Call("Write", data)
+		return record.Ids()[0]
+	}
+	return imp.rc.Call("Create", data).(models.RecordSet).Ids()[0]
+}
+
+// convertValue returns the value to set in the field described by fInfo from the
+// given cell value. subField is the sub field of relation fields ("id", ".id" or
+// empty to search by name). Many2many fields take comma separated values.
+func (imp *recordImporter) convertValue(value string, fInfo *models.FieldInfo, subField string) (interface{}, error) {
+	switch fInfo.Type {
+	case fieldtype.Boolean:
+		switch strings.ToLower(value) {
+		case "1", "true", "yes", "y", "t", "x":
+			return true, nil
+		case "0", "false", "no", "n", "f":
+			return false, nil
+		}
+		return nil, fmt.Errorf("unknown boolean value '%s'", value)
+	case fieldtype.Integer:
+		return strconv.ParseInt(imp.normalizeNumber(value), 10, 64)
+	case fieldtype.Float:
+		return strconv.ParseFloat(imp.normalizeNumber(value), 64)
+	case fieldtype.Date:
+		return dates.ParseDateWithLayout(dates.DefaultServerDateFormat, value)
+	case fieldtype.DateTime:
+		return dates.ParseDateTimeWithLayout(dates.DefaultServerDateTimeFormat, value)
+	case fieldtype.Selection:
+		for key, label := range fInfo.Selection {
+			if strings.EqualFold(value, key) || strings.EqualFold(value, label) {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("value '%s' not found in selection", value)
+	case fieldtype.Many2One, fieldtype.One2One:
+		return imp.findRecords(fInfo, []string{value}, subField)
+	case fieldtype.Many2Many:
+		return imp.findRecords(fInfo, strings.Split(value, ","), subField)
+	}
+	return value, nil
+}
+
+// normalizeNumber removes the thousand separators of the given number value
+// and replaces its decimal separator with a dot.
+func (imp *recordImporter) normalizeNumber(value string) string {
+	if imp.options.FloatThousandSeparator != "" {
+		value = strings.Replace(value, imp.options.FloatThousandSeparator, "", -1)
+	}
+	if imp.options.FloatDecimalSeparator != "" && imp.options.FloatDecimalSeparator != "." {
+		value = strings.Replace(value, imp.options.FloatDecimalSeparator, ".", -1)
+	}
+	return value
+}
+
+// findRecords returns the records related by the field described by fInfo that match the given
+// values, which are external IDs if subField is "id", database IDs if subField is ".id" and
+// names otherwise.
+func (imp *recordImporter) findRecords(fInfo *models.FieldInfo, values []string, subField string) (*models.RecordCollection, error) {
+	comodel := imp.rc.Env().Pool(fInfo.Relation)
+	res := comodel
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		var rec *models.RecordCollection
+		switch subField {
+		case "id":
+			rec = comodel.Search(comodel.Model().Field(comodel.Model().FieldName("HexyaExternalID")).Equals(value))
+		case ".id":
+			dbID, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid database ID '%s'", value)
+			}
+			rec = comodel.Search(comodel.Model().Field(models.ID).Equals(dbID))
+		default:
+			matches := comodel.Call("NameSearch", webtypes.NameSearchParams{
+				Name:     value,
+				Operator: operator.Equals,
+				Limit:    2,
+			}).([]webtypes.RecordIDWithName)
+			if len(matches) > 1 {
+				imp.addWarning(fInfo.JSON, "Found several records named '%s', the first one is used", value)
+			}
+			rec = comodel
+			if len(matches) > 0 {
+				rec = comodel.Search(comodel.Model().Field(models.ID).Equals(matches[0].ID))
+			}
+		}
+		if rec.IsEmpty() {
+			return nil, fmt.Errorf("no matching record found for '%s'", value)
+		}
+		res = res.Union(rec)
+	}
+	return res, nil
+}
+
+// Search extends the standard method so that users other
+// than the superuser only get the import wizards they created.
+func importWizard_Search(rs m.ImportWizardSet, cond q.ImportWizardCondition) m.ImportWizardSet {
+	if rs.Env().Uid() != security.SuperUserID {
+		cond = cond.AndCond(q.ImportWizard().CreateUID().Equals(rs.Env().Uid()))
+	}
+	return rs.Super().Search(cond)
+}
+
+func init() {
+	models.NewTransientModel("ImportWizard")
+	h.ImportWizard().AddFields(fields_ImportWizard)
+	h.ImportWizard().NewMethod("ImportFields", importWizard_ImportFields)
+	h.ImportWizard().NewMethod("ParsePreview", importWizard_ParsePreview)
+	h.ImportWizard().NewMethod("MatchHeader", importWizard_MatchHeader)
+	h.ImportWizard().NewMethod("ReadFile", importWizard_ReadFile)
+	h.ImportWizard().NewMethod("Do", importWizard_Do)
+	h.ImportWizard().Methods().Search().Extend(importWizard_Search)
+}
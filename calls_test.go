@@ -17,6 +17,7 @@ import (
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
 )
 
 func TestExecute(t *testing.T) {
@@ -475,3 +476,38 @@ company. You can use the "Discard" button to abandon this change.`)
 		})
 	})
 }
+
+func TestEnforceModifiers(t *testing.T) {
+	Convey("Testing enforcement of view modifiers on write", t, func() {
+		viper.Set("Web.EnforceModifiers", true)
+		defer viper.Set("Web.EnforceModifiers", false)
+		params := controllers.CallParams{
+			Model:  "User",
+			Method: "write",
+			Args: []json.RawMessage{
+				json.RawMessage(fmt.Sprintf(`[%d]`, security.SuperUserID)),
+				json.RawMessage(`{"partner_id": 1}`),
+			},
+			KWArgs: map[string]json.RawMessage{
+				"context": json.RawMessage(`{"lang":"en_US"}`),
+			},
+		}
+		Convey("Writing a readonly field should fail", func() {
+			_, err := controllers.Execute(security.SuperUserID, params)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Field 'partner_id' is read only")
+		})
+		Convey("Naming another view in the context should not bypass the check", func() {
+			params.KWArgs["context"] = json.RawMessage(`{"lang":"en_US","form_view_ref":"base_view_users_form_simple_modif"}`)
+			params.Args[1] = json.RawMessage(`{"chatter_position": "normal"}`)
+			_, err := controllers.Execute(security.SuperUserID, params)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Field 'chatter_position' cannot be written from this view")
+		})
+		Convey("Writing a writable field should succeed", func() {
+			params.Args[1] = json.RawMessage(`{"signature": "<p>John</p>", "email": "admin@example.com"}`)
+			_, err := controllers.Execute(security.SuperUserID, params)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -9,16 +9,28 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"regexp"
+	"strings"
 )
 
+// csrfTokenRegexp matches the CSRF token in the login page and in the web client
+var csrfTokenRegexp = regexp.MustCompile(`csrf_token(?:" value=|: )"([0-9a-f]+)"`)
+
 // Hexya wraps a http.Client
 type Hexya struct {
 	http.Client
 	Url string
+	// APIKey is sent as a bearer token with RPC calls if set,
+	// so that the client does not need to Login.
+	APIKey string
+	// CSRFToken is the CSRF token of the client session. It is
+	// updated from the HTML pages returned by Post and PostForm.
+	CSRFToken string
 }
 
 // NewHexyaClient returns a new Hexya Client to make requests from.
@@ -40,13 +52,70 @@ func (hc *Hexya) Login(username, password string) error {
 	vals := make(url.Values)
 	vals.Add("login", username)
 	vals.Add("password", password)
-	_, err := hc.Client.PostForm(hc.Url+"/web/login", vals)
+	resp, err := hc.PostForm(hc.Url+"/web/login", vals)
 	if err != nil {
 		return fmt.Errorf("error while logging: %s", err)
 	}
+	resp.Body.Close()
+	return nil
+}
+
+// FetchCSRFToken gets the CSRF token of the client session from the login
+// page, which redirects to the web client if the client is logged in.
+func (hc *Hexya) FetchCSRFToken() error {
+	resp, err := hc.Client.Get(hc.Url + "/web/login")
+	if err != nil {
+		return fmt.Errorf("error while fetching CSRF token: %s", err)
+	}
+	defer resp.Body.Close()
+	return hc.updateCSRFToken(resp)
+}
+
+// updateCSRFToken sets the CSRFToken of the client if the body of the given
+// response contains one. The body is replaced so that it can be read again.
+func (hc *Hexya) updateCSRFToken(resp *http.Response) error {
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("error while reading response body: %s", err)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if match := csrfTokenRegexp.FindSubmatch(data); match != nil {
+		hc.CSRFToken = string(match[1])
+	}
 	return nil
 }
 
+// Post issues a POST to the given URL with the CSRF token of the client
+// session in the X-CSRF-Token header.
+func (hc *Hexya) Post(url, contentType string, body io.Reader) (*http.Response, error) {
+	if hc.CSRFToken == "" {
+		if err := hc.FetchCSRFToken(); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-CSRF-Token", hc.CSRFToken)
+	resp, err := hc.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		err = hc.updateCSRFToken(resp)
+	}
+	return resp, err
+}
+
+// PostForm issues a POST to the given URL with the given form data
+// and the CSRF token of the client session.
+func (hc *Hexya) PostForm(url string, data url.Values) (*http.Response, error) {
+	return hc.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
 // RPC executes a RPC call to the given method on the given URI with the given params.
 // params must be json serializable.
 // Returned value is the result message.
@@ -61,7 +130,17 @@ func (hc *Hexya) RPC(uri, method string, params interface{}) (json.RawMessage, e
 	if err != nil {
 		return nil, fmt.Errorf("error while marshalling data: %s", err)
 	}
-	res, err := hc.Client.Post(hc.Url+uri, "application/json", bytes.NewReader(data))
+	req, err := http.NewRequest(http.MethodPost, hc.Url+uri, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error while creating request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hc.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+hc.APIKey)
+	} else {
+		req.Header.Set("X-CSRF-Token", hc.CSRFToken)
+	}
+	res, err := hc.Client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error while sending request: %s", err)
 	}
@@ -5,18 +5,20 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/beevik/etree"
-	"github.com/hexya-addons/web/controllers"
 	"github.com/hexya-addons/web/domains"
 	"github.com/hexya-addons/web/webtypes"
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/tools/strutils"
 	"github.com/hexya-erp/hexya/src/tools/typesutils"
@@ -373,19 +375,32 @@ func commonMixin_FieldsViewGet(rs m.CommonMixinSet, args webtypes.FieldsViewGetP
 	}
 	fInfos := rs.FieldsGet(models.FieldsGetArgs{Fields: cols})
 	arch := rs.ProcessView(view.Arch(lang), fInfos)
-	toolbar := rs.GetToolbar()
 	res := webtypes.FieldsViewData{
-		Name:    view.Name,
-		Arch:    arch,
-		ViewID:  args.ViewID,
-		Model:   view.Model,
-		Type:    view.Type,
-		Toolbar: toolbar,
-		Fields:  fInfos,
+		Name:   view.Name,
+		Arch:   arch,
+		ViewID: args.ViewID,
+		Model:  view.Model,
+		Type:   view.Type,
+		Fields: fInfos,
+	}
+	if args.Toolbar {
+		res.Toolbar = rs.GetToolbar(view.Type)
+	}
+	if debugMode(rs.Env().Context()) && canSeeRawArch(rs.Env()) {
+		rawArch, err := view.Arch(lang).WriteToString()
+		if err != nil {
+			log.Panic("Unable to write view arch", "view", view.ID, "error", err)
+		}
+		res.RawArch = rawArch
+		res.InheritedViews = inheritedViews(view.ID)
 	}
 	// Sub views
 	for field, sViews := range view.SubViews {
 		fJSON := rs.Collection().Model().JSONizeFieldName(field)
+		if _, ok := fInfos[fJSON]; !ok {
+			// This field has been removed from the view
+			continue
+		}
 		relRS := rs.Env().Pool(fInfos[fJSON].Relation)
 		if res.Fields[fJSON].Views == nil {
 			res.Fields[fJSON].Views = make(map[string]interface{})
@@ -425,21 +440,45 @@ func commonMixin_LoadViews(rs m.CommonMixinSet, args webtypes.LoadViewsArgs) *we
 		})
 	}
 	if args.Options.LoadFilters {
-		// TODO: doesn't work
-		res.Filters = controllers.MethodAdapters["GetFilters"](h.Filter().NewSet(rs.Env()).Collection(),
-			"GetFilters",
-			[]interface{}{rs.ModelName(), args.Options.ActionID}).([]models.FieldMap)
+		filterSet := h.Filter().NewSet(rs.Env())
+		fInfos := filterSet.FieldsGet(models.FieldsGetArgs{})
+		res.Filters = []models.FieldMap{}
+		for _, filter := range filterSet.GetFilters(rs.ModelName(), int64(args.Options.ActionID)) {
+			filterData := filterSet.AddNamesToRelations(filter, fInfos)
+			res.Filters = append(res.Filters, filterData.Underlying().FieldMap)
+		}
 	}
 	res.Fields = rs.FieldsGet(models.FieldsGetArgs{})
 	return &res
 }
 
 // GetToolbar returns a toolbar populated with the actions linked to this model
-func commonMixin_GetToolbar(rs m.CommonMixinSet) webtypes.Toolbar {
+// that are available to the current user in a view of the given type.
+//
+// Report actions are put in the Print section, window actions in the Relate
+// section and other actions in the Action section. Actions restricted to groups
+// the user does not belong to are left out. Toolbars are only available in list
+// and form views, and actions with the Multi flag are only bound to list views.
+func commonMixin_GetToolbar(rs m.CommonMixinSet, viewType views.ViewType) webtypes.Toolbar {
 	var res webtypes.Toolbar
+	switch viewType {
+	case views.ViewTypeList, views.ViewTypeTree, views.ViewTypeForm:
+	default:
+		return res
+	}
 	for _, a := range actions.Registry.GetActionLinksForModel(rs.ModelName()) {
+		if a.Multi && viewType == views.ViewTypeForm {
+			continue
+		}
+		if len(a.Groups) > 0 && !userHasGroups(rs.Env().Uid(), a.Groups) {
+			continue
+		}
 		switch a.Type {
-		case actions.ActionActWindow, actions.ActionServer:
+		case actions.ActionReport:
+			res.Print = append(res.Print, a)
+		case actions.ActionActWindow:
+			res.Relate = append(res.Relate, a)
+		case actions.ActionServer, actions.ActionClient, actions.ActionURL:
 			res.Action = append(res.Action, a)
 		}
 	}
@@ -453,6 +492,7 @@ func commonMixin_ProcessView(rs m.CommonMixinSet, arch *etree.Document, fieldInf
 	doc := arch.Copy()
 	// Apply changes
 	rs.ManageGroupsOnFields(doc, fieldInfos)
+	processSearchPanel(rs, doc)
 	rs.AddModifiers(doc, fieldInfos)
 	// Dump xml to string and return
 	res, err := doc.WriteToString()
@@ -462,30 +502,59 @@ func commonMixin_ProcessView(rs m.CommonMixinSet, arch *etree.Document, fieldInf
 	return res
 }
 
-// ManageGroupsOnFields adds the invisible attribute to fields nodes if the current
-// user does not belong to one of the groups of the 'groups' attribute
+// ManageGroupsOnFields removes from the given doc all the nodes with a 'groups' attribute
+// if the current user does not belong to one of these groups. The 'groups'
+// attribute is removed from the other nodes.
+//
+// Fields that do not appear in the arch anymore are also removed from
+// fieldInfos so that their data is not sent to unauthorized users.
+//
+// Despite its name, this method handles all the nodes of the view and not only
+// the fields. The name is kept for the addons that override it.
 func commonMixin_ManageGroupsOnFields(rs m.CommonMixinSet, doc *etree.Document, fieldInfos map[string]*models.FieldInfo) {
-	for _, fieldTag := range doc.FindElements("//field") {
-		groupsString := fieldTag.SelectAttrValue("groups", "")
-		if groupsString == "" {
+	var removedFields []string
+	for _, elt := range doc.FindElements("//*[@groups]") {
+		groupsString := elt.SelectAttrValue("groups", "")
+		elt.RemoveAttr("groups")
+		if userHasGroups(rs.Env().Uid(), strings.Split(groupsString, ",")) {
 			continue
 		}
-		groups := strings.Split(groupsString, ",")
-		var hasGroup bool
-		for _, g := range groups {
-			group := security.Registry.GetGroup(g)
-			if security.Registry.HasMembership(rs.Env().Uid(), group) {
-				hasGroup = true
-				break
-			}
+		if elt.Tag == "field" {
+			removedFields = append(removedFields, elt.SelectAttrValue("name", ""))
 		}
-		if !hasGroup {
-			fieldTag.CreateAttr("invisible", "1")
+		for _, fieldTag := range elt.FindElements(".//field") {
+			removedFields = append(removedFields, fieldTag.SelectAttrValue("name", ""))
 		}
+		elt.Parent().RemoveChild(elt)
 	}
+	for _, fieldName := range removedFields {
+		if doc.FindElement(fmt.Sprintf("//field[@name='%s']", fieldName)) != nil {
+			// This field is still displayed somewhere else in the view
+			continue
+		}
+		delete(fieldInfos, fieldName)
+	}
+}
+
+// userHasGroups returns true if the user with the given uid
+// belongs to at least one of the given groups.
+func userHasGroups(uid int64, groups []string) bool {
+	for _, g := range groups {
+		group := security.Registry.GetGroup(strings.TrimSpace(g))
+		if group == nil {
+			continue
+		}
+		if security.Registry.HasMembership(uid, group) {
+			return true
+		}
+	}
+	return false
 }
 
 // AddModifiers adds the modifiers attribute nodes to given xml doc.
+//
+// Context expressions found in modifiers (such as "context.get('key')") are
+// resolved with the context of the current RecordSet.
 func commonMixin_AddModifiers(rs m.CommonMixinSet, doc *etree.Document, fieldInfos map[string]*models.FieldInfo) {
 	allModifiers := make(map[*etree.Element]map[string]interface{})
 	// Process attrs on all nodes
@@ -497,11 +566,12 @@ func commonMixin_AddModifiers(rs m.CommonMixinSet, doc *etree.Document, fieldInf
 		mods, exists := allModifiers[fieldTag]
 		if !exists {
 			mods = map[string]interface{}{"readonly": false, "required": false, "invisible": false,
-				"tree_invisible": false}
+				"tree_invisible": false, "column_invisible": false}
 		}
 		allModifiers[fieldTag] = rs.ProcessFieldElementModifiers(fieldTag, fieldInfos, mods)
 	}
 	// Set modifier attributes on elements
+	viewType := doc.Root().Tag
 	for element, modifiers := range allModifiers {
 		// Remove false or not applicable keys
 		for mod, val := range modifiers {
@@ -510,7 +580,12 @@ func commonMixin_AddModifiers(rs m.CommonMixinSet, doc *etree.Document, fieldInf
 				delete(modifiers, mod)
 				continue
 			}
-			viewType := doc.Root().Tag
+			if mod == "column_invisible" {
+				if viewType != string(views.ViewTypeTree) {
+					delete(modifiers, mod)
+				}
+				continue
+			}
 			toks := strings.Split(mod, "_")
 			if len(toks) > 1 && toks[0] != viewType {
 				delete(modifiers, mod)
@@ -532,31 +607,32 @@ func commonMixin_AddModifiers(rs m.CommonMixinSet, doc *etree.Document, fieldInf
 }
 
 // ProcessFieldElementModifiers modifies the given modifiers map by taking into account:
-// - 'invisible', 'readonly' and 'required' attributes in field tags
+// - 'invisible', 'column_invisible', 'readonly' and 'required' attributes in field tags
 // - 'ReadOnly' and 'Required' parameters of the model's field'
 // It returns the modified map.
+//
+// View specific modifiers such as 'tree_invisible' are set from the attribute without
+// the view prefix. In list views, the 'invisible' attribute also hides the whole column.
+// As in attrs, a field attribute evaluated to false does not override a modifier domain.
 func commonMixin_ProcessFieldElementModifiers(rs m.CommonMixinSet, element *etree.Element, fieldInfos map[string]*models.FieldInfo, modifiers map[string]interface{}) map[string]interface{} {
 	fieldName := element.SelectAttr("name").Value
 	// Check if we have the modifier as attribute in the field node
 	for modifier := range modifiers {
 		modView := modifier
-		toks := strings.Split(modifier, "_")
-		if len(toks) > 1 {
+		if toks := strings.Split(modifier, "_"); len(toks) > 1 && modifier != "column_invisible" {
 			modView = toks[1]
 		}
 		modTag := element.SelectAttrValue(modView, "")
+		if modifier == "column_invisible" && modTag == "" {
+			modTag = element.SelectAttrValue("invisible", "")
+		}
 		if modTag == "" {
 			continue
 		}
-		modVal, err := strconv.ParseBool(modTag)
-		if modVal || err != nil {
-			// If we have an error, we assume it is true
-			modifiers[modView] = true
-			modifiers[modifier] = true
-			continue
+		modVal := evalModifierExpression(modTag, rs.Env().Context())
+		if _, isBool := modifiers[modifier].(bool); modVal || isBool {
+			modifiers[modifier] = modVal
 		}
-		modifiers[modView] = false
-		modifiers[modifier] = false
 	}
 	// Force modifiers if defined in the model
 	if fieldInfos[fieldName].ReadOnlyFunc != nil {
@@ -593,22 +669,29 @@ func commonMixin_ProcessFieldElementModifiers(rs m.CommonMixinSet, element *etre
 }
 
 // ProcessElementAttrs returns a modifiers map according to the domain
-// in attrs of the given element
+// in attrs of the given element.
+//
+// Domains may reference fields of the parent record with the 'parent.' prefix
+// when the element is in a sub-view. Since 'column_invisible' is evaluated by the
+// client on the parent record itself, this prefix is removed from its domain.
 func commonMixin_ProcessElementAttrs(rc *models.RecordCollection, element *etree.Element, fieldInfos map[string]*models.FieldInfo) map[string]interface{} {
-	modifiers := map[string]interface{}{"readonly": false, "required": false, "invisible": false}
+	modifiers := map[string]interface{}{"readonly": false, "required": false, "invisible": false, "column_invisible": false}
 	attrStr := element.SelectAttrValue("attrs", "")
 	if attrStr == "" {
 		return modifiers
 	}
 	var attrs map[string]domains.Domain
-	attrStr = strutils.DictToJSON(attrStr)
+	attrStr = strutils.DictToJSON(resolveContextExpressions(attrStr, rc.Env().Context()))
 	err := json.Unmarshal([]byte(attrStr), &attrs)
 	if err != nil {
 		log.Panic("Invalid attrs definition", "model", rc.ModelName(), "error", err, "attrs", attrStr)
 	}
 	for modifier := range modifiers {
-		cond := domains.ParseDomain(attrs[modifier], rc.Model())
-		if cond.IsEmpty() {
+		if len(attrs[modifier]) == 0 {
+			continue
+		}
+		if modifier == "column_invisible" {
+			modifiers[modifier] = removeParentPrefix(attrs[modifier])
 			continue
 		}
 		modifiers[modifier] = attrs[modifier]
@@ -617,6 +700,68 @@ func commonMixin_ProcessElementAttrs(rc *models.RecordCollection, element *etree
 	return modifiers
 }
 
+// contextExpression matches "context.get('key')", "context.get('key', default)"
+// and "context['key']" expressions in view attributes.
+var contextExpression = regexp.MustCompile(`context(?:\.get\(\s*['"]([^'"]+)['"]\s*(?:,\s*([^)]*?))?\s*\)|\[\s*['"]([^'"]+)['"]\s*\])`)
+
+// resolveContextExpressions returns the given expression with all context expressions
+// replaced by the JSON representation of the corresponding value in ctx.
+func resolveContextExpressions(expr string, ctx *types.Context) string {
+	return contextExpression.ReplaceAllStringFunc(expr, func(match string) string {
+		sub := contextExpression.FindStringSubmatch(match)
+		key, def := sub[1], sub[2]
+		if key == "" {
+			key = sub[3]
+		}
+		if !ctx.HasKey(key) || ctx.Get(key) == nil {
+			switch def {
+			case "", "None":
+				return "null"
+			default:
+				return def
+			}
+		}
+		res, err := json.Marshal(ctx.Get(key))
+		if err != nil {
+			log.Panic("Unable to marshal context value", "key", key, "error", err)
+		}
+		return string(res)
+	})
+}
+
+// evalModifierExpression returns the boolean value of the given modifier attribute
+// value, after resolving context expressions. Unknown expressions evaluate to true.
+func evalModifierExpression(expr string, ctx *types.Context) bool {
+	expr = strings.TrimSpace(resolveContextExpressions(expr, ctx))
+	if strings.HasPrefix(expr, "not ") {
+		return !evalModifierExpression(strings.TrimPrefix(expr, "not "), ctx)
+	}
+	if val, err := strconv.ParseBool(expr); err == nil {
+		return val
+	}
+	switch expr {
+	case "", "None", "null", "0", "0.0", `""`, "''", "[]", "{}":
+		return false
+	}
+	return true
+}
+
+// removeParentPrefix returns a copy of the given domain in which the
+// 'parent.' prefix has been removed from all field names.
+func removeParentPrefix(dom domains.Domain) domains.Domain {
+	res := make(domains.Domain, len(dom))
+	for i, term := range dom {
+		leaf, ok := term.([]interface{})
+		if !ok || len(leaf) != 3 {
+			res[i] = term
+			continue
+		}
+		fieldName, _ := leaf[0].(string)
+		res[i] = []interface{}{strings.TrimPrefix(fieldName, "parent."), leaf[1], leaf[2]}
+	}
+	return res
+}
+
 // SearchRead retrieves database records according to the filters defined in params.
 func commonMixin_SearchRead(rs m.CommonMixinSet, params webtypes.SearchParams) []models.RecordData {
 	rSet := rs.AddDomainLimitOffset(params.Domain, models.ConvertLimitToInt(params.Limit), params.Offset, params.Order)
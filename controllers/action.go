@@ -77,10 +77,11 @@ func ActionRun(c *server.Context) {
 
 	// Execute the function
 	resAction, _ := Execute(c.Session().Get("uid").(int64), CallParams{
-		Model:  action.Model,
-		Method: action.Method,
-		Args:   []json.RawMessage{idsJSON},
-		KWArgs: kwargs,
+		Model:      action.Model,
+		Method:     action.Method,
+		Args:       []json.RawMessage{idsJSON},
+		KWArgs:     kwargs,
+		CompanyIDs: sessionCompanyIDs(c.Session()),
 	})
 
 	if _, ok := resAction.(*actions.Action); ok {
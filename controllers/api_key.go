@@ -0,0 +1,213 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/hexya-addons/web/odooproxy"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+)
+
+// apiKeyAuthenticated is the key set in the request context
+// when the request is authenticated by an API key.
+const apiKeyAuthenticated = "api_key_authenticated"
+
+// sessionOnlyMethods are the methods of each model that manage the account of
+// the user and its security. They cannot be called by requests authenticated
+// by an API key, so that a leaked key cannot be used to take over the account.
+//
+// Writing on users is refused too, since changing the email of the account
+// would allow to take it over through the password reset.
+var sessionOnlyMethods = map[string]map[string]bool{
+	"User": {
+		"Write":          true,
+		"ChangePassword": true,
+		"ResetPassword":  true,
+		"TOTPEnroll":     true,
+		"TOTPConfirm":    true,
+		"TOTPReset":      true,
+	},
+	"APIKey": {
+		"Create":      true,
+		"Write":       true,
+		"Unlink":      true,
+		"GenerateKey": true,
+		"Revoke":      true,
+	},
+	"UserSession": {
+		"Create":              true,
+		"Write":               true,
+		"Unlink":              true,
+		"Revoke":              true,
+		"RevokeOtherSessions": true,
+	},
+}
+
+// sessionOnly wraps the given controller so that it refuses requests
+// authenticated by an API key with a 403 status.
+//
+// It must be used for all the routes that manage the account of the user
+// and its security, which require a real login.
+func sessionOnly(fnct server.HandlerFunc) server.HandlerFunc {
+	return func(c *server.Context) {
+		if c.GetBool(apiKeyAuthenticated) {
+			log.Info("account route refused to API key", "path", c.Request.URL.Path, "ip", c.ClientIP())
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		fnct(c)
+	}
+}
+
+// refuseSessionOnlyMethod aborts the current request with a 403 status and
+// returns true if it is authenticated by an API key and calls one of the
+// sessionOnlyMethods.
+func refuseSessionOnlyMethod(c *server.Context, params CallParams) bool {
+	if !c.GetBool(apiKeyAuthenticated) {
+		return false
+	}
+	modelName := odooproxy.ConvertModelName(params.Model)
+	methodName := odooproxy.ConvertMethodName(params.Method)
+	if !sessionOnlyMethods[modelName][methodName] {
+		return false
+	}
+	log.Info("account method refused to API key", "model", modelName, "method", methodName, "ip", c.ClientIP())
+	c.AbortWithStatus(http.StatusForbidden)
+	return true
+}
+
+// bearerToken returns the token of the Authorization header of the request
+// and true if the request is authenticated with a bearer token.
+func bearerToken(c *server.Context) (string, bool) {
+	auth := c.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), true
+}
+
+// apiKeyScope returns the API key scope required by the current request
+func apiKeyScope(c *server.Context) string {
+	if strings.HasPrefix(c.Request.URL.Path, "/web/dataset/") {
+		return "dataset"
+	}
+	return "rpc"
+}
+
+// apiKeyRequired authenticates the current JSON-RPC request with the given
+// API key instead of the session cookie, or aborts it with a 401 status.
+//
+// The user of the key is set in the session for the controllers, but
+// the session is not saved so that no cookie is sent back.
+func apiKeyRequired(c *server.Context, key string) {
+	var (
+		uid   int64
+		login string
+	)
+	if c.ContentType() == binding.MIMEJSON {
+		models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			uid = h.APIKey().NewSet(env).FindUser(key, apiKeyScope(c))
+			if uid != 0 {
+				login = h.User().BrowseOne(env, uid).Login()
+			}
+		})
+	}
+	if uid == 0 {
+		log.Info("request refused with invalid API key", "path", c.Request.URL.Path, "ip", c.ClientIP())
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	sess := c.Session()
+	sess.Set("uid", uid)
+	sess.Set("login", login)
+	// There is no UserSession record for API keys
+	sess.Set("ID", int64(0))
+	c.Set(apiKeyAuthenticated, true)
+}
+
+// APIKeyCreateParams are the params of the APIKeyCreate controller
+type APIKeyCreateParams struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// ExpirationDate is formatted as 'YYYY-MM-DD HH:MM:SS' or empty if the key never expires
+	ExpirationDate string `json:"expiration_date"`
+}
+
+// APIKeyCreate creates a new API key for the current user and returns it.
+// The key cannot be retrieved afterwards.
+func APIKeyCreate(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	params := APIKeyCreateParams{Scope: "rpc"}
+	c.BindRPCParams(&params)
+	var res string
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		var expirationDate dates.DateTime
+		if params.ExpirationDate != "" {
+			expirationDate = dates.ParseDateTime(params.ExpirationDate)
+		}
+		res = h.APIKey().NewSet(env).GenerateKey(params.Name, params.Scope, expirationDate)
+	})
+	c.RPC(http.StatusOK, res, err)
+}
+
+// APIKeyInfo is the description of an API key returned by APIKeyList
+type APIKeyInfo struct {
+	ID             int64          `json:"id"`
+	Name           string         `json:"name"`
+	Scope          string         `json:"scope"`
+	CreateDate     dates.DateTime `json:"create_date"`
+	ExpirationDate dates.DateTime `json:"expiration_date"`
+	LastUse        dates.DateTime `json:"last_use"`
+}
+
+// APIKeyList returns the API keys of the current user
+func APIKeyList(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	res := make([]APIKeyInfo, 0)
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		apiKeys := h.APIKey().Search(env, q.APIKey().UserFilteredOn(q.User().ID().Equals(uid)))
+		for _, apiKey := range apiKeys.Records() {
+			res = append(res, APIKeyInfo{
+				ID:             apiKey.ID(),
+				Name:           apiKey.Name(),
+				Scope:          apiKey.Scope(),
+				CreateDate:     apiKey.CreateDate(),
+				ExpirationDate: apiKey.ExpirationDate(),
+				LastUse:        apiKey.LastUse(),
+			})
+		}
+	})
+	c.RPC(http.StatusOK, res, err)
+}
+
+// APIKeyRevokeParams are the params of the APIKeyRevoke controller
+type APIKeyRevokeParams struct {
+	ID int64 `json:"id"`
+}
+
+// APIKeyRevoke revokes the API key with the given ID of the current user
+func APIKeyRevoke(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	var params APIKeyRevokeParams
+	c.BindRPCParams(&params)
+	var res bool
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		apiKey := h.APIKey().Search(env, q.APIKey().ID().Equals(params.ID).
+			And().UserFilteredOn(q.User().ID().Equals(uid)))
+		if apiKey.IsEmpty() {
+			log.Panic("Unknown API key", "id", params.ID)
+		}
+		res = apiKey.Revoke()
+	})
+	c.RPC(http.StatusOK, res, err)
+}
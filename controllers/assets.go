@@ -94,17 +94,27 @@ func AssetsFrontendCSS(c *server.Context) {
 // Dashboard returns the dashboard image of the company or the default one
 func Dashboard(c *server.Context) {
 	CheckUser(c.Session().Get("uid").(int64))
-	var image []byte
+	var (
+		image       []byte
+		notModified bool
+	)
 	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
 		user := h.User().Search(env, q.User().ID().Equals(c.Session().Get("uid").(int64)))
 		if user.Company().DashboardBackground() == "" {
 			return
 		}
+		etag := computeETag("dashboard", user.Company().ID(), user.Company().LastUpdate())
+		if notModified = isNotModified(c, etag, user.Company().LastUpdate().Time); notModified {
+			return
+		}
 		image, _ = base64.StdEncoding.DecodeString(user.Company().DashboardBackground())
 	})
+	if notModified {
+		return
+	}
 	if len(image) == 0 {
 		c.Redirect(http.StatusFound, "/static/web/src/img/material-background.png")
 		return
 	}
-	c.Data(http.StatusOK, "image", image)
+	c.Data(http.StatusOK, imageContentType(image), image)
 }
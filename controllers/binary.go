@@ -5,44 +5,77 @@ package controllers
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/hexya-addons/web/odooproxy"
+	"github.com/hexya-addons/web/webtypes"
 	"github.com/hexya-erp/hexya/src/menus"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
 	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
 	"github.com/hexya-erp/pool/h"
+	"github.com/spf13/viper"
 )
 
 // CompanyLogo serves the logo of the company
 func CompanyLogo(c *server.Context) {
 	info := GetSessionInfoStruct(c.Session())
-	var img string
-	switch {
-	case info == nil:
-		// Not connected. Get image of administrator company
-		models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
-			img = h.User().NewSet(env).BrowseOne(security.SuperUserID).Company().LogoWeb()
-		})
-	default:
+	var (
+		img         string
+		notModified bool
+	)
+	uid, companyID := security.SuperUserID, int64(0)
+	if info != nil {
 		// Connected. Get image of session's company
-		models.ExecuteInNewEnvironment(info.UID, func(env models.Environment) {
-			img = h.Company().NewSet(env).BrowseOne(info.CompanyID).LogoWeb()
-		})
+		uid, companyID = info.UID, info.CompanyID
+	}
+	models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		company := h.Company().NewSet(env).BrowseOne(companyID)
+		if info == nil {
+			// Not connected. Get image of administrator company
+			company = h.User().NewSet(env).BrowseOne(security.SuperUserID).Company()
+		}
+		etag := computeETag("company_logo", company.ID(), company.LastUpdate())
+		if notModified = isNotModified(c, etag, company.LastUpdate().Time); notModified {
+			return
+		}
+		img = company.LogoWeb()
+	})
+	if notModified {
+		return
 	}
 	res, err := base64.StdEncoding.DecodeString(img)
 	if err != nil || img == "" {
 		c.File(filepath.Join(server.ResourceDir, "static", "web", "src", "img", "nologo.png"))
 		return
 	}
-	c.Data(http.StatusOK, "image/png", res)
+	c.Data(http.StatusOK, imageContentType(res), res)
 }
 
 // Image serves the image stored in the database (base64 encoded)
-// in the given model and given field
+// in the given model and given field.
+//
+// The response can be cached by the client and is revalidated
+// with an ETag computed from the last update of the record.
+//
+// The image is resized to the '<width>x<height>' path segment following
+// the field name, or to the 'width' and 'height' query parameters, and
+// cropped to this ratio if the 'crop' query parameter is set.
 func Image(c *server.Context) {
 	getFunc := c.Param
 	if _, ok := c.Params.Get("model"); !ok {
@@ -52,25 +85,96 @@ func Image(c *server.Context) {
 	field := getFunc("field")
 	id, err := strconv.ParseInt(getFunc("id"), 10, 64)
 	if err != nil {
-		c.Error(fmt.Errorf("unable to read image ID: %s", err))
+		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	uid := c.Session().Get("uid").(int64)
-	img, gErr := getFieldValue(uid, id, model, field)
-	if gErr != nil {
-		c.Error(fmt.Errorf("unable to fetch image: %s", gErr))
+	uid := sessionUID(c)
+	if uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	size := parseImageSize(c, c.Param("any"))
+	lastUpdate, status, err := checkImageAccess(uid, id, model, field)
+	switch {
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("unable to fetch image: %s", err))
+		return
+	case status != http.StatusOK:
+		c.AbortWithStatus(status)
+		return
+	}
+	if isNotModified(c, computeETag(model, id, field, lastUpdate, size), lastUpdate) {
+		return
+	}
+	img, err := getFieldValue(uid, id, model, field)
+	if err == errContentForbidden {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("unable to fetch image: %s", err))
 		return
 	}
 	if img.(string) == "" {
 		c.File(filepath.Join(server.ResourceDir, "static", "web", "src", "img", "placeholder.png"))
 		return
 	}
-	res, err := base64.StdEncoding.DecodeString(img.(string))
+	data, err := base64.StdEncoding.DecodeString(img.(string))
 	if err != nil {
-		c.Error(fmt.Errorf("unable to convert image: %s", err))
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("unable to convert image: %s", err))
 		return
 	}
-	c.Data(http.StatusOK, "image/png", res)
+	res, contentType, err := resizedImageData(data, size)
+	switch {
+	case err == errImageTooLarge:
+		c.AbortWithError(http.StatusUnprocessableEntity, err)
+		return
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("unable to resize image: %s", err))
+		return
+	}
+	if isActiveContent(contentType) {
+		// SVG images may run scripts if they are opened directly
+		c.Header("Content-Disposition", contentDisposition("attachment", field))
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, contentType, res)
+}
+
+// checkImageAccess checks that the user with the given uid can read the given binary field
+// of the record with the given model and id, and returns the last update of the record.
+//
+// The returned status is http.StatusNotFound if the model, field or record does not exist,
+// http.StatusForbidden if the field is not a binary field that the user can read or if the
+// user cannot read the model and http.StatusOK otherwise.
+func checkImageAccess(uid, id int64, model, field string) (lastUpdate time.Time, status int, rError error) {
+	model = odooproxy.ConvertModelName(model)
+	mi, ok := models.Registry.Get(model)
+	if !ok {
+		return lastUpdate, http.StatusNotFound, nil
+	}
+	if _, ok := mi.Fields().Get(field); !ok {
+		return lastUpdate, http.StatusNotFound, nil
+	}
+	status = http.StatusOK
+	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		rc := env.Pool(model)
+		if !isReadableField(rc, field, fieldtype.Binary) {
+			status = http.StatusForbidden
+			return
+		}
+		if !rc.Call("CheckAccessRights", webtypes.CheckAccessRightsArgs{Operation: "read"}).(bool) {
+			status = http.StatusForbidden
+			return
+		}
+		rc = rc.Search(rc.Model().Field(models.ID).Equals(id))
+		if rc.IsEmpty() {
+			status = http.StatusNotFound
+			return
+		}
+		lastUpdate = rc.Get(rc.Model().FieldName("LastUpdate")).(dates.DateTime).Time
+	})
+	return
 }
 
 // MenuImage serves the image for the given menu
@@ -79,8 +183,386 @@ func MenuImage(c *server.Context) {
 	menu := menus.Registry.GetByID(menuID)
 	if menu != nil && menu.WebIcon != "" {
 		fp := filepath.Join(server.ResourceDir, menu.WebIcon)
+		if info, err := os.Stat(fp); err == nil {
+			setCacheHeaders(c, computeETag(fp, info.ModTime()), info.ModTime())
+		}
 		c.File(fp)
 		return
 	}
 	c.File(filepath.Join(server.ResourceDir, "static", "web", "src", "img", "placeholder.png"))
 }
+
+// defaultMaxUploadSize is the maximum size in bytes of uploaded
+// files when the Web.MaxUploadSize configuration key is not set.
+const defaultMaxUploadSize = 25 * 1024 * 1024
+
+// uploadFormOverhead is the size in bytes allowed for the fields and part headers
+// of upload forms, in addition to the maximum size of the uploaded files.
+const uploadFormOverhead = 64 * 1024
+
+// errContentNotFound is returned by binaryContent when the
+// requested record does not exist or the field is empty.
+var errContentNotFound = errors.New("content not found")
+
+// errContentForbidden is returned by binaryContent when the requested
+// field is not a binary field or when the user cannot read the model.
+var errContentForbidden = errors.New("access denied")
+
+// maxUploadSize returns the maximum size in bytes of uploaded files
+func maxUploadSize() int64 {
+	if size := viper.GetInt64("Web.MaxUploadSize"); size > 0 {
+		return size
+	}
+	return defaultMaxUploadSize
+}
+
+// maxFormSize returns the maximum size in bytes of the body of form requests
+func maxFormSize() int64 {
+	return maxUploadSize() + uploadFormOverhead
+}
+
+// UploadAttachment creates an attachment for each file uploaded in the
+// 'ufile' form value, linked to the record given by 'model' and 'id'.
+//
+// Files larger than the Web.MaxUploadSize configuration key are rejected, and
+// the request is refused if the user cannot write on the record.
+// The result is returned as JSON, or as a script that triggers the
+// 'callback' event on the parent window if this form value is set.
+func UploadAttachment(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	CheckUser(uid)
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFormSize())
+	form, err := c.MultipartForm()
+	if err != nil {
+		fileError(c, fmt.Errorf("unable to read uploaded files: %s", err))
+		return
+	}
+	model := odooproxy.ConvertModelName(c.PostForm("model"))
+	resID, _ := strconv.ParseInt(c.PostForm("id"), 10, 64)
+	if err := checkAttachmentTarget(uid, model, resID); err != nil {
+		fileError(c, err)
+		return
+	}
+	var res []map[string]interface{}
+	for _, fileHeader := range form.File["ufile"] {
+		if fileHeader.Size > maxUploadSize() {
+			res = append(res, map[string]interface{}{
+				"filename": fileHeader.Filename,
+				"error":    fmt.Sprintf("File is too large, the maximum size is %d bytes", maxUploadSize()),
+			})
+			continue
+		}
+		attachment, err := createAttachment(uid, model, resID, fileHeader)
+		if err != nil {
+			res = append(res, map[string]interface{}{
+				"filename": fileHeader.Filename,
+				"error":    err.Error(),
+			})
+			continue
+		}
+		res = append(res, attachment)
+	}
+	callback := c.PostForm("callback")
+	if callback == "" {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	callbackJSON, _ := json.Marshal(callback)
+	resJSON, _ := json.Marshal(res)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(fmt.Sprintf(`<script language="javascript" type="text/javascript">
+	var win = window.top.window;
+	win.jQuery(win).trigger(%s, %s);
+</script>`, callbackJSON, resJSON)))
+}
+
+// checkAttachmentTarget returns an error if the user with the given uid cannot write on
+// the record with the given model and ID, to which files are being attached. Files that
+// are not attached to a record are always allowed.
+func checkAttachmentTarget(uid int64, model string, resID int64) error {
+	if model == "" && resID == 0 {
+		return nil
+	}
+	if _, ok := models.Registry.Get(model); !ok || resID == 0 {
+		return exceptions.UserError{Message: fmt.Sprintf("Unknown record %s,%d", model, resID)}
+	}
+	var allowed bool
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		rc := env.Pool(model)
+		if !rc.Call("CheckAccessRights", webtypes.CheckAccessRightsArgs{Operation: "write"}).(bool) {
+			return
+		}
+		allowed = rc.Search(rc.Model().Field(models.ID).Equals(resID)).IsNotEmpty()
+	})
+	switch {
+	case err != nil:
+		return err
+	case !allowed:
+		return exceptions.UserError{Message: "You are not allowed to attach files to this record"}
+	}
+	return nil
+}
+
+// createAttachment creates an attachment for the given uploaded file, linked to the record
+// with the given model and ID, and returns the data expected by the client for this attachment.
+func createAttachment(uid int64, model string, resID int64, fileHeader *multipart.FileHeader) (res map[string]interface{}, rError error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		attachment := h.Attachment().Create(env, h.Attachment().NewData().
+			SetName(fileHeader.Filename).
+			SetType("binary").
+			SetDatas(base64.StdEncoding.EncodeToString(content)).
+			SetResModel(model).
+			SetResID(resID))
+		res = map[string]interface{}{
+			"filename": fileHeader.Filename,
+			"mimetype": attachment.MimeType(),
+			"id":       attachment.ID(),
+			"size":     attachment.FileSize(),
+		}
+	})
+	return
+}
+
+// Content serves the content of a binary field. The record can be given in the
+// URL path, either as '/<attachment_id>[/<filename>]' for attachments or as
+// '/<model>/<id>/<field>[/<filename>]', or with the request parameters.
+//
+// The following request parameters are also accepted:
+// - filename_field: the field holding the file name
+// - filename: the file name, if filename_field is not given
+// - download: if 'true', the file is sent as an attachment instead of inline.
+//
+// Files that browsers could execute, such as HTML or SVG files, are always sent
+// as attachments. URL attachments are redirected to only if their URL is on this
+// server or on a host of the Web.RedirectAllowedHosts configuration key.
+func Content(c *server.Context) {
+	params := map[string]string{
+		"model": "ir.attachment",
+		"field": "datas",
+	}
+	for _, key := range []string{"model", "id", "field", "filename_field", "filename", "download"} {
+		if val := c.Query(key); val != "" {
+			params[key] = val
+		}
+		if val := c.PostForm(key); val != "" {
+			params[key] = val
+		}
+	}
+	var pathParts []string
+	for _, part := range strings.Split(c.Param("path"), "/") {
+		if part != "" {
+			pathParts = append(pathParts, part)
+		}
+	}
+	if len(pathParts) > 0 {
+		if _, err := strconv.ParseInt(pathParts[0], 10, 64); err == nil {
+			pathParts = append([]string{"ir.attachment", pathParts[0], "datas"}, pathParts[1:]...)
+		}
+	}
+	if len(pathParts) >= 3 {
+		params["model"], params["id"], params["field"] = pathParts[0], pathParts[1], pathParts[2]
+		if len(pathParts) > 3 {
+			params["filename"] = pathParts[3]
+		}
+	}
+	serveContent(c, params, params["download"] == "true")
+}
+
+// SaveAs sends the content of the binary field given by the 'model', 'id' and 'field'
+// request parameters as a file to download. The file name is read in the field given
+// by the 'filename_field' parameter if any.
+func SaveAs(c *server.Context) {
+	params := make(map[string]string)
+	for _, key := range []string{"model", "id", "field", "filename_field", "filename"} {
+		params[key] = c.Query(key)
+		if val := c.PostForm(key); val != "" {
+			params[key] = val
+		}
+	}
+	serveContent(c, params, true)
+}
+
+// serveContent sends the binary content given by params (see Content). The file is sent
+// as an attachment if download is true and inline otherwise.
+func serveContent(c *server.Context, params map[string]string, download bool) {
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "invalid record ID")
+		return
+	}
+	uid := c.Session().Get("uid").(int64)
+	content, err := binaryContent(uid, params["model"], id, params["field"], params["filename_field"])
+	switch {
+	case err == errContentNotFound:
+		c.String(http.StatusNotFound, err.Error())
+		return
+	case err == errContentForbidden:
+		c.String(http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		fileError(c, err)
+		return
+	case content.URL != "" && safeRedirect(content.URL) != content.URL:
+		// URL attachments can be created by any user and must not redirect to other sites
+		c.String(http.StatusForbidden, errContentForbidden.Error())
+		return
+	case content.URL != "":
+		c.Redirect(http.StatusFound, content.URL)
+		return
+	}
+	fileName := content.FileName
+	if fileName == "" {
+		fileName = params["filename"]
+	}
+	if fileName == "" {
+		fileName = fmt.Sprintf("%s-%d-%s", odooproxy.ConvertModelName(params["model"]), id, params["field"])
+	}
+	mimeType := content.MimeType
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(content.Data)
+	}
+	disposition := "inline"
+	if download || isActiveContent(mimeType) {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", contentDisposition(disposition, fileName))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, mimeType, content.Data)
+}
+
+// activeContentTypes are the mime types that browsers may render as documents
+// running scripts, which must never be served inline from the application
+// origin since they can be uploaded by any user.
+var activeContentTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/xml":               true,
+	"application/xml":        true,
+	"text/javascript":        true,
+	"application/javascript": true,
+}
+
+// isActiveContent returns true if content of the given mime type may run scripts
+// when it is displayed by the browser.
+func isActiveContent(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return true
+	}
+	return activeContentTypes[strings.ToLower(mediaType)]
+}
+
+// A binaryFile is the content of a binary field with its metadata
+type binaryFile struct {
+	Data     []byte
+	FileName string
+	MimeType string
+	URL      string
+}
+
+// binaryContent returns the content of the given binary field of the record with the given
+// model and ID. The file name is read from filenameField if it is set, which must be a char
+// field that the user can read.
+//
+// Attachments get their file name and mime type from their own fields, and URL attachments
+// only have their URL set. It returns errContentNotFound if the record does not exist or
+// if the field is empty, and errContentForbidden if the field is not a binary field, if
+// filenameField is not a readable char field or if the user cannot read the model.
+func binaryContent(uid int64, model string, id int64, field, filenameField string) (res binaryFile, rError error) {
+	CheckUser(uid)
+	_, status, err := checkImageAccess(uid, id, model, field)
+	switch {
+	case err != nil:
+		return res, err
+	case status == http.StatusNotFound:
+		return res, errContentNotFound
+	case status != http.StatusOK:
+		return res, errContentForbidden
+	}
+	var (
+		data      string
+		notFound  bool
+		forbidden bool
+	)
+	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		model = odooproxy.ConvertModelName(model)
+		rc := env.Pool(model)
+		rc = rc.Search(rc.Model().Field(models.ID).Equals(id))
+		if rc.IsEmpty() {
+			notFound = true
+			return
+		}
+		if model == "Attachment" {
+			attachment := h.Attachment().BrowseOne(env, id)
+			if attachment.Type() == "url" {
+				res.URL = attachment.URL()
+				return
+			}
+			res.FileName = attachment.Name()
+			res.MimeType = attachment.MimeType()
+		}
+		if filenameField != "" {
+			if !isReadableField(rc, filenameField, fieldtype.Char) {
+				forbidden = true
+				return
+			}
+			res.FileName, _ = rc.Get(rc.Model().FieldName(filenameField)).(string)
+		}
+		data, _ = rc.Get(rc.Model().FieldName(field)).(string)
+	})
+	if rError != nil {
+		return
+	}
+	if forbidden {
+		return res, errContentForbidden
+	}
+	if notFound || (data == "" && res.URL == "") {
+		return res, errContentNotFound
+	}
+	res.Data, rError = base64.StdEncoding.DecodeString(data)
+	return
+}
+
+// isReadableField returns true if the given field of the model of rc exists, is of
+// one of the given types and is described by FieldsGet to the user of rc, which
+// excludes the fields whose access is restricted to other users.
+func isReadableField(rc *models.RecordCollection, field string, types ...fieldtype.Type) bool {
+	f, ok := rc.Model().Fields().Get(field)
+	if !ok {
+		return false
+	}
+	fInfos := rc.Call("FieldsGet", models.FieldsGetArgs{Fields: models.FieldNames{f}}).(map[string]*models.FieldInfo)
+	fInfo, ok := fInfos[f.JSON()]
+	if !ok {
+		return false
+	}
+	for _, typ := range types {
+		if fInfo.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// contentDisposition returns the value of a Content-Disposition header with
+// the given disposition type for the given file name.
+//
+// Non ASCII file names are encoded as defined in RFC 5987.
+func contentDisposition(disposition, fileName string) string {
+	if res := mime.FormatMediaType(disposition, map[string]string{"filename": fileName}); res != "" {
+		return res
+	}
+	return fmt.Sprintf("%s; filename*=utf-8''%s", disposition, url.PathEscape(fileName))
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hexya-addons/web/scripts"
+	"github.com/hexya-erp/hexya/src/i18n"
+	"github.com/hexya-erp/hexya/src/menus"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+)
+
+// A hashCache keeps the hashes of content that is expensive to compute,
+// indexed by a key which changes when the content changes.
+type hashCache struct {
+	sync.RWMutex
+	hashes map[string]string
+}
+
+// get returns the hash of the given key, calling compute
+// to get it if it is not in the cache yet.
+func (hc *hashCache) get(key string, compute func() string) string {
+	hc.RLock()
+	hash, ok := hc.hashes[key]
+	hc.RUnlock()
+	if ok {
+		return hash
+	}
+	hash = compute()
+	hc.Lock()
+	defer hc.Unlock()
+	if hc.hashes == nil {
+		hc.hashes = make(map[string]string)
+	}
+	hc.hashes[key] = hash
+	return hash
+}
+
+var (
+	// menusHashes are the hashes of the menu tree by language
+	menusHashes hashCache
+	// qwebHashes are the hashes of the client templates by modules
+	qwebHashes hashCache
+	// translationsHashes are the hashes of the client translations by language and modules
+	translationsHashes hashCache
+)
+
+// cacheHashes returns the cache_hashes of the session info of the user with
+// the given id and language, so that the client can cache the menus, the
+// client templates and the translations until they change.
+func cacheHashes(uid int64, lang string) map[string]string {
+	mods := server.Modules.Names()
+	return map[string]string{
+		"load_menus":   menusHash(uid, lang),
+		"qweb":         qwebHash(mods),
+		"translations": translationsHash(lang, mods),
+	}
+}
+
+// menusData returns the root menu of the application in the given language
+func menusData(lang string) gin.H {
+	var allRootMenuIds []int64
+	for _, menu := range menus.Registry.All() {
+		allRootMenuIds = append(allRootMenuIds, menu.ID)
+	}
+	return gin.H{
+		"name":         "root",
+		"parent_id":    parentTuple{"-1", ""},
+		"children":     getMenuTree(menus.Registry.Menus, lang),
+		"all_menu_ids": allRootMenuIds,
+	}
+}
+
+// menusHash returns the hash of the menus of the user with the given id in the
+// given language. It depends on the groups of the user as well as on the
+// menu tree, since the menus that can be loaded depend on these groups.
+func menusHash(uid int64, lang string) string {
+	treeHash := menusHashes.get(lang, func() string {
+		data, _ := json.Marshal(menusData(lang))
+		return computeHash(string(data))
+	})
+	var groupIDs []string
+	for group := range security.Registry.UserGroups(uid) {
+		groupIDs = append(groupIDs, group.ID())
+	}
+	sort.Strings(groupIDs)
+	return computeHash(treeHash, lang, strings.Join(groupIDs, ","))
+}
+
+// qwebData returns the concatenated client templates of the given modules
+func qwebData(mods []string) ([]byte, error) {
+	fileNames := tools.ListStaticFiles(server.ResourceDir, "src/xml", mods, true)
+	res, _, err := xmlutils.ConcatXML(fileNames)
+	return res, err
+}
+
+// qwebHash returns the hash of the concatenated client templates of the given modules.
+//
+// Client templates are static files that are loaded when the server starts, so the
+// hash is only computed once for each list of modules.
+func qwebHash(mods []string) string {
+	return qwebHashes.get(strings.Join(mods, ","), func() string {
+		data, err := qwebData(mods)
+		if err != nil {
+			log.Warn("error while computing client side QWeb hash", "error", err)
+		}
+		return computeHash(string(data))
+	})
+}
+
+// translationsData returns the client translations of the given language
+func translationsData(lang string) gin.H {
+	return gin.H{
+		"lang_parameters": i18n.GetLocale(lang),
+		"modules":         scripts.ListModuleTranslations(lang),
+		"multi_lang":      true,
+	}
+}
+
+// translationsHash returns the hash of the client translations
+// of the given language for the given modules.
+func translationsHash(lang string, mods []string) string {
+	key := lang + ":" + strings.Join(mods, ",")
+	return translationsHashes.get(key, func() string {
+		data, _ := json.Marshal(translationsData(lang))
+		return computeHash(key, string(data))
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// userCompanyIDs returns the IDs of the companies the given user
+// may work in, starting with its default company.
+func userCompanyIDs(user m.UserSet) []int64 {
+	res := []int64{user.Company().ID()}
+	for _, id := range user.Companies().Ids() {
+		if id != res[0] {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// validCompanyIDs returns the given company IDs that the given user may work
+// in, in the same order. If none is valid, it returns the default company
+// of the user only.
+func validCompanyIDs(user m.UserSet, companyIDs []int64) []int64 {
+	allowed := make(map[int64]bool)
+	for _, id := range userCompanyIDs(user) {
+		allowed[id] = true
+	}
+	var res []int64
+	for _, id := range companyIDs {
+		if allowed[id] {
+			res = append(res, id)
+			allowed[id] = false
+		}
+	}
+	if len(res) == 0 {
+		return []int64{user.Company().ID()}
+	}
+	return res
+}
+
+// sessionCompanyIDs returns the companies selected in the given session,
+// starting with the current company, or nil if none has been selected.
+func sessionCompanyIDs(sess sessions.Session) []int64 {
+	ids, _ := sess.Get("company_ids").([]int64)
+	return ids
+}
+
+// companyContext returns the given context with the current and allowed
+// companies of the current user of env, so that methods and record rules
+// can work on the active companies only.
+//
+// The companies are taken from the allowed_company_ids key of the context,
+// or from the given sessionCompanyIDs if the context has none. They are
+// restricted to the companies of the user, and the first one is set as
+// current company in the company_id key.
+func companyContext(env models.Environment, ctx *types.Context, sessionCompanyIDs []int64) *types.Context {
+	if ctx == nil {
+		ctx = types.NewContext()
+	}
+	user := h.User().NewSet(env).CurrentUser().Sudo()
+	if user.IsEmpty() {
+		return ctx
+	}
+	companyIDs := sessionCompanyIDs
+	if ctx.HasKey("allowed_company_ids") {
+		companyIDs = ctx.GetIntegerSlice("allowed_company_ids")
+	}
+	companyIDs = validCompanyIDs(user, companyIDs)
+	return ctx.
+		WithKey("allowed_company_ids", companyIDs).
+		WithKey("company_id", companyIDs[0])
+}
+
+// SwitchCompanyParams are the params of the SwitchCompany controller
+type SwitchCompanyParams struct {
+	CompanyID         int64   `json:"company_id"`
+	AllowedCompanyIDs []int64 `json:"allowed_company_ids"`
+}
+
+// SwitchCompany sets the current company and the allowed companies of the
+// session, which are used by model calls that do not set allowed_company_ids
+// in their context.
+//
+// The current company is added to the allowed companies if needed, and
+// companies of which the user is not a member are ignored. The resulting
+// companies are returned.
+func SwitchCompany(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	var params SwitchCompanyParams
+	c.BindRPCParams(&params)
+	companyIDs := []int64{params.CompanyID}
+	for _, id := range params.AllowedCompanyIDs {
+		if id != params.CompanyID {
+			companyIDs = append(companyIDs, id)
+		}
+	}
+	var res UserCompanies
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		user := h.User().NewSet(env).CurrentUser().Sudo()
+		companyIDs = validCompanyIDs(user, companyIDs)
+		companies := h.Company().Browse(env, companyIDs)
+		for _, company := range companies.Records() {
+			rec := webtypes.RecordIDWithName{ID: company.ID(), Name: company.Name()}
+			if company.ID() == companyIDs[0] {
+				res.CurrentCompany = rec
+			}
+			res.AllowedCompanies = append(res.AllowedCompanies, rec)
+		}
+	})
+	if err == nil && !c.GetBool(apiKeyAuthenticated) {
+		sess := c.Session()
+		sess.Set("company_ids", companyIDs)
+		sess.Save()
+	}
+	c.RPC(http.StatusOK, res, err)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// CSRFHeader is the header in which JSON-RPC clients send the CSRF token
+// of their session. Forms send it in the csrf_token field instead.
+const CSRFHeader = "X-CSRF-Token"
+
+// csrfExemptPaths are the paths whose POST requests are not checked for a CSRF token
+var csrfExemptPaths = make(map[string]bool)
+
+// ExemptFromCSRF disables the CSRF check of the POST requests to the given
+// path. It is meant for public endpoints called by third parties that
+// cannot know the CSRF token, such as webhooks, and must not be used for
+// endpoints that rely on the session cookie.
+//
+// This function must be called before the server starts, typically
+// in the PreInit function of a module.
+func ExemptFromCSRF(path string) {
+	csrfExemptPaths[path] = true
+}
+
+// csrfToken returns the CSRF token of the current session,
+// creating it if the session has none yet.
+func csrfToken(c *server.Context) string {
+	sess := c.Session()
+	if token, ok := sess.Get("csrf_token").(string); ok && token != "" {
+		return token
+	}
+	token, err := newSessionKey()
+	if err != nil {
+		log.Panic("unable to generate CSRF token", "error", err)
+	}
+	sess.Set("csrf_token", token)
+	sess.Save()
+	return token
+}
+
+// CSRFProtected is a middleware that refuses requests with unsafe methods that
+// do not carry the CSRF token of their session, so that other sites cannot
+// make requests on behalf of logged in users.
+//
+// JSON-RPC calls must send the token in the X-CSRF-Token header and forms
+// either in this header or in their csrf_token field. Forms without the header
+// are refused if they are larger than the maximum upload size. JSON-RPC calls that are not authenticated by the
+// session cookie, including those authenticated by an API key, are exempt.
+func CSRFProtected(c *server.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if _, ok := bearerToken(c); ok || csrfExemptPaths[c.Request.URL.Path] {
+		return
+	}
+	sent := c.GetHeader(CSRFHeader)
+	if c.ContentType() == binding.MIMEJSON {
+		if c.Session().Get("uid") == nil {
+			return
+		}
+	} else if sent == "" {
+		if c.Request.ContentLength > maxFormSize() {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		// The body is limited before the form is parsed, so that the upload
+		// size limit cannot be bypassed by sending the token in the form.
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxFormSize())
+		sent = c.PostForm("csrf_token")
+	}
+	token, _ := c.Session().Get("csrf_token").(string)
+	if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		log.Warn("request refused because of invalid CSRF token", "path", c.Request.URL.Path, "ip", c.ClientIP())
+		c.String(http.StatusForbidden, "Invalid or missing CSRF token. Please reload the page and try again.")
+		c.Abort()
+	}
+}
@@ -15,6 +15,10 @@ func CallKW(c *server.Context) {
 	uid := c.Session().Get("uid").(int64)
 	var params CallParams
 	c.BindRPCParams(&params)
+	if refuseSessionOnlyMethod(c, params) {
+		return
+	}
+	params.CompanyIDs = sessionCompanyIDs(c.Session())
 	res, err := Execute(uid, params)
 	c.RPC(http.StatusOK, res, err)
 }
@@ -25,6 +29,10 @@ func CallButton(c *server.Context) {
 	uid := c.Session().Get("uid").(int64)
 	var params CallParams
 	c.BindRPCParams(&params)
+	if refuseSessionOnlyMethod(c, params) {
+		return
+	}
+	params.CompanyIDs = sessionCompanyIDs(c.Session())
 	res, err := Execute(uid, params)
 	switch act := res.(type) {
 	case actions.Action:
@@ -42,6 +50,7 @@ func SearchReadController(c *server.Context) {
 	uid := c.Session().Get("uid").(int64)
 	var params SearchReadParams
 	c.BindRPCParams(&params)
+	params.CompanyIDs = sessionCompanyIDs(c.Session())
 	res, err := SearchRead(uid, params)
 	c.RPC(http.StatusOK, res, err)
 }
@@ -0,0 +1,302 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-addons/web/odooproxy"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+)
+
+// An ExportFormat is a file format records can be exported to
+type ExportFormat struct {
+	Tag   string `json:"tag"`
+	Label string `json:"label"`
+}
+
+// exportFormats lists the available export formats
+var exportFormats = []ExportFormat{
+	{Tag: "xlsx", Label: "XLSX"},
+	{Tag: "csv", Label: "CSV"},
+}
+
+// ExportedField is a field to export with its column label
+type ExportedField struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+}
+
+// ExportParams is the args struct of the export controllers.
+//
+// Records with the given IDs are exported if any, otherwise
+// all the records matching the given domain are exported.
+type ExportParams struct {
+	Model        string          `json:"model"`
+	Fields       []ExportedField `json:"fields"`
+	IDs          json.RawMessage `json:"ids"`
+	Domain       domains.Domain  `json:"domain"`
+	Context      types.Context   `json:"context"`
+	ImportCompat bool            `json:"import_compat"`
+}
+
+// ExportFieldsParams is the args struct of the ExportGetFields controller
+type ExportFieldsParams struct {
+	Model           string   `json:"model"`
+	Prefix          string   `json:"prefix"`
+	ParentName      string   `json:"parent_name"`
+	ImportCompat    bool     `json:"import_compat"`
+	ParentFieldType string   `json:"parent_field_type"`
+	Exclude         []string `json:"exclude"`
+}
+
+// ExportFieldsParentParams holds the parameters needed
+// to fetch the sub fields of a relation field to export.
+type ExportFieldsParentParams struct {
+	Model       string            `json:"model"`
+	Prefix      string            `json:"prefix"`
+	Name        string            `json:"name"`
+	ParentField *models.FieldInfo `json:"parent_field"`
+}
+
+// ExportFieldItem describes a field that can be exported
+type ExportFieldItem struct {
+	ID            string                    `json:"id"`
+	String        string                    `json:"string"`
+	Value         string                    `json:"value"`
+	Children      bool                      `json:"children"`
+	FieldType     fieldtype.Type            `json:"field_type"`
+	Required      bool                      `json:"required"`
+	RelationField string                    `json:"relation_field,omitempty"`
+	Params        *ExportFieldsParentParams `json:"params,omitempty"`
+}
+
+// ExportFormats returns the available export formats
+func ExportFormats(c *server.Context) {
+	var params struct{}
+	c.BindRPCParams(&params)
+	c.RPC(http.StatusOK, exportFormats)
+}
+
+// ExportGetFields returns the fields of the given model that can be exported
+func ExportGetFields(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	var params ExportFieldsParams
+	c.BindRPCParams(&params)
+	res, err := exportFields(uid, params)
+	c.RPC(http.StatusOK, res, err)
+}
+
+// exportFields returns the list of fields that can be exported for the given params.
+//
+// Relation fields have children down to the second level. In import compatible
+// mode, readonly fields are not listed and many2one and many2many relations
+// only give their name and external ID.
+func exportFields(uid int64, params ExportFieldsParams) (res []ExportFieldItem, rError error) {
+	CheckUser(uid)
+	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		rs := env.Pool(odooproxy.ConvertModelName(params.Model))
+		fInfos := rs.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)
+		delete(fInfos, "hexya_external_id")
+		delete(fInfos, "hexya_version")
+		idInfo := *fInfos["id"]
+		idInfo.String = "External ID"
+		if !params.ImportCompat {
+			fInfos[".id"] = fInfos["id"]
+		}
+		fInfos["id"] = &idInfo
+		relCompat := params.ImportCompat && (params.ParentFieldType == string(fieldtype.Many2One) ||
+			params.ParentFieldType == string(fieldtype.Many2Many))
+		if relCompat {
+			fInfos = map[string]*models.FieldInfo{"id": fInfos["id"], "name": fInfos["name"]}
+			if fInfos["name"] == nil {
+				delete(fInfos, "name")
+			}
+		}
+
+		fNames := make([]string, 0, len(fInfos))
+		for fName := range fInfos {
+			fNames = append(fNames, fName)
+		}
+		sort.Slice(fNames, func(i, j int) bool {
+			return fInfos[fNames[i]].String < fInfos[fNames[j]].String
+		})
+		exclude := make(map[string]bool)
+		for _, e := range params.Exclude {
+			exclude[e] = true
+		}
+		for _, fName := range fNames {
+			fInfo := fInfos[fName]
+			if params.ImportCompat && fName != "id" && (exclude[fName] || fInfo.ReadOnly) {
+				continue
+			}
+			id := fName
+			if params.Prefix != "" {
+				id = params.Prefix + "/" + fName
+			}
+			name := fInfo.String
+			if params.ParentName != "" {
+				name = params.ParentName + "/" + fInfo.String
+			}
+			item := ExportFieldItem{
+				ID:            id,
+				String:        name,
+				Value:         id,
+				FieldType:     fInfo.Type,
+				Required:      fInfo.Required,
+				RelationField: fInfo.ReverseFK,
+			}
+			if fName == "name" && relCompat {
+				item.Value = params.Prefix
+			}
+			if fInfo.Relation != "" && len(strings.Split(id, "/")) < 3 {
+				item.Value += "/id"
+				item.Children = true
+				item.Params = &ExportFieldsParentParams{
+					Model:       fInfo.Relation,
+					Prefix:      id,
+					Name:        name,
+					ParentField: fInfo,
+				}
+			}
+			res = append(res, item)
+		}
+	})
+	return
+}
+
+// ExportNameList returns the fields of the given export template with their labels
+func ExportNameList(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	params := struct {
+		Model    string `json:"model"`
+		ExportID int64  `json:"export_id"`
+	}{}
+	c.BindRPCParams(&params)
+	exportIDJSON, _ := json.Marshal(params.ExportID)
+	res, err := Execute(uid, CallParams{
+		Model:      "ExportTemplate",
+		Method:     "field_list",
+		Args:       []json.RawMessage{exportIDJSON},
+		CompanyIDs: sessionCompanyIDs(c.Session()),
+	})
+	c.RPC(http.StatusOK, res, err)
+}
+
+// ExportCSV exports records in a CSV file
+func ExportCSV(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	params, headers, rows, err := exportRequestData(c, uid)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		fileError(c, err)
+		return
+	}
+	serveFile(c, params.Model+".csv", "text/csv;charset=utf8", buf.Bytes())
+}
+
+// ExportXLSX exports records in an XLSX file
+func ExportXLSX(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	params, headers, rows, err := exportRequestData(c, uid)
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, params.Model, headers, rows); err != nil {
+		fileError(c, err)
+		return
+	}
+	serveFile(c, params.Model+".xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
+
+// exportRequestData reads the export params from the 'data' form value of the
+// request and returns them with the column headers and the exported rows.
+//
+// Column headers are the field names in import compatible mode, so that the
+// file can be imported back, and the field labels otherwise.
+func exportRequestData(c *server.Context, uid int64) (ExportParams, []string, [][]string, error) {
+	var params ExportParams
+	if err := json.Unmarshal([]byte(c.PostForm("data")), &params); err != nil {
+		return params, nil, nil, fmt.Errorf("unable to read export parameters: %s", err)
+	}
+	headers := make([]string, len(params.Fields))
+	fields := make([]string, len(params.Fields))
+	for i, f := range params.Fields {
+		fields[i] = f.Name
+		headers[i] = f.Label
+		if params.ImportCompat {
+			headers[i] = f.Name
+		}
+	}
+	rows, err := exportData(uid, params, fields)
+	return params, headers, rows, err
+}
+
+// exportData returns the rows of the given fields
+// for the records defined by the given params.
+func exportData(uid int64, params ExportParams, fields []string) (res [][]string, rError error) {
+	CheckUser(uid)
+	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		ctx := params.Context.WithKey("import_compat", params.ImportCompat)
+		rs := env.Pool(odooproxy.ConvertModelName(params.Model)).WithNewContext(ctx)
+		var ids []int64
+		if json.Unmarshal(params.IDs, &ids) == nil && len(ids) > 0 {
+			rs = rs.Search(rs.Model().Field(models.ID).In(ids))
+		} else {
+			rs = rs.Call("AddDomainLimitOffset", params.Domain, 0, 0, "").(models.RecordSet).Collection()
+		}
+		res = rs.Call("ExportData", fields).([][]string)
+	})
+	return
+}
+
+// serveFile sends the given data as a file attachment with the given file name
+func serveFile(c *server.Context, fileName, contentType string, data []byte) {
+	c.Header("Content-Disposition", contentDisposition("attachment", fileName))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// fileError sends the given error as an HTML page holding the JSON-RPC error,
+// which is the format expected by the client when downloading a file.
+func fileError(c *server.Context, err error) {
+	errData := server.JSONRPCError{
+		Code:    http.StatusOK,
+		Message: "Hexya Server Error",
+		Data: server.JSONRPCErrorData{
+			Arguments:     []string{err.Error()},
+			ExceptionType: "internal_error",
+		},
+	}
+	if userError, ok := err.(exceptions.UserError); ok {
+		errData.Data = server.JSONRPCErrorData{
+			Arguments:     []string{userError.Message},
+			ExceptionType: "user_error",
+			Debug:         userError.Debug,
+		}
+	}
+	errJSON, _ := json.Marshal(errData)
+	c.Data(http.StatusInternalServerError, "text/html; charset=utf-8", []byte(fmt.Sprintf(
+		"<html><body><h1>%s</h1><p>%s</p></body></html>", errData.Message, html.EscapeString(string(errJSON)))))
+}
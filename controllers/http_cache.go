@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/server"
+)
+
+// cacheLongMaxAge is the max age in seconds of the responses to requests
+// with a 'unique' query parameter or a matching ':unique' path parameter.
+const cacheLongMaxAge = 365 * 24 * 3600
+
+// computeHash returns the hexadecimal SHA1 hash of the given parts
+func computeHash(parts ...interface{}) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprint(parts...))))
+}
+
+// computeETag returns a strong ETag computed from the given parts
+func computeETag(parts ...interface{}) string {
+	return fmt.Sprintf(`"%s"`, computeHash(parts...))
+}
+
+// setCacheHeaders sets the ETag, Last-Modified and Cache-Control headers of the response.
+//
+// Responses to requests with a 'unique' query parameter are cached for a year since the
+// client changes this parameter when the content changes. Other responses must be
+// revalidated by the client at each use.
+func setCacheHeaders(c *server.Context, etag string, lastModified time.Time) {
+	writeCacheHeaders(c, etag, lastModified, c.Query("unique") != "")
+}
+
+// writeCacheHeaders sets the ETag, Last-Modified and Cache-Control headers of
+// the response. The response is cached for a year if immutable is true.
+func writeCacheHeaders(c *server.Context, etag string, lastModified time.Time, immutable bool) {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if immutable {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", cacheLongMaxAge))
+		return
+	}
+	c.Header("Cache-Control", "private, no-cache")
+}
+
+// isNotModified returns true if the client cache is up to date given the etag
+// and lastModified of the requested content, in which case a 304 Not Modified
+// response is sent. Cache headers are set in all cases.
+func isNotModified(c *server.Context, etag string, lastModified time.Time) bool {
+	setCacheHeaders(c, etag, lastModified)
+	return checkNotModified(c, etag, lastModified)
+}
+
+// isUniqueNotModified is the equivalent of isNotModified for the routes with a
+// ':unique' path parameter, which the client sets to the given hash taken from
+// the cache_hashes of the session info.
+//
+// Responses are cached for a year when this parameter matches the hash, since the
+// hash changes with the content. Otherwise, for instance when the client has
+// invalidated its cache, they must be revalidated by the client at each use.
+func isUniqueNotModified(c *server.Context, hash, etag string) bool {
+	writeCacheHeaders(c, etag, time.Time{}, c.Param("unique") == hash)
+	return checkNotModified(c, etag, time.Time{})
+}
+
+// checkNotModified sends a 304 Not Modified response and returns true if the
+// conditional headers of the request match the given etag and lastModified.
+func checkNotModified(c *server.Context, etag string, lastModified time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				c.Status(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+	if !lastModified.Truncate(time.Second).After(ims) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,328 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/spf13/viper"
+)
+
+// resizedImagesCacheSize is the maximum total size in bytes of the resized images kept in cache
+const resizedImagesCacheSize = 64 * 1024 * 1024
+
+// defaultMaxImageDimension is the maximum width or height that can be requested
+// for an image when the Web.MaxImageDimension configuration key is not set.
+const defaultMaxImageDimension = 4096
+
+// defaultMaxImagePixels is the maximum number of pixels of the images that are decoded
+// to be resized when the Web.MaxImagePixels configuration key is not set.
+const defaultMaxImagePixels = 50 * 1000 * 1000
+
+// errImageTooLarge is returned when an image has too many pixels to be resized
+var errImageTooLarge = errors.New("image is too large to be resized")
+
+// imageJPEGQuality is the quality of resized JPEG images
+const imageJPEGQuality = 90
+
+// An imageSize is the size requested by the client for an image.
+//
+// A zero width or height means that this dimension is not constrained.
+// If Crop is set and both dimensions are given, the image is cropped
+// at its center to the requested ratio before being resized.
+type imageSize struct {
+	Width  int
+	Height int
+	Crop   bool
+}
+
+// isZero returns true if this imageSize does not require any resizing
+func (s imageSize) isZero() bool {
+	return s.Width <= 0 && s.Height <= 0
+}
+
+// maxImageDimension returns the maximum width or height that can be requested for an image
+func maxImageDimension() int {
+	if dim := viper.GetInt("Web.MaxImageDimension"); dim > 0 {
+		return dim
+	}
+	return defaultMaxImageDimension
+}
+
+// maxImagePixels returns the maximum number of pixels of the images that are decoded to be resized
+func maxImagePixels() int {
+	if pixels := viper.GetInt("Web.MaxImagePixels"); pixels > 0 {
+		return pixels
+	}
+	return defaultMaxImagePixels
+}
+
+// parseImageSize returns the imageSize given by the 'width', 'height'
+// and 'crop' query parameters, or by the first '<width>x<height>'
+// segment of the given path if any.
+//
+// Dimensions are bounded by the Web.MaxImageDimension configuration key.
+func parseImageSize(c *server.Context, path string) imageSize {
+	var res imageSize
+	res.Width, _ = strconv.Atoi(c.Query("width"))
+	res.Height, _ = strconv.Atoi(c.Query("height"))
+	res.Crop, _ = strconv.ParseBool(c.Query("crop"))
+	for _, part := range strings.Split(path, "/") {
+		dims := strings.Split(part, "x")
+		if len(dims) != 2 {
+			continue
+		}
+		width, wErr := strconv.Atoi(dims[0])
+		height, hErr := strconv.Atoi(dims[1])
+		if wErr != nil || hErr != nil {
+			continue
+		}
+		res.Width, res.Height = width, height
+		break
+	}
+	maxDim := maxImageDimension()
+	res.Width = maxInt(0, minInt(res.Width, maxDim))
+	res.Height = maxInt(0, minInt(res.Height, maxDim))
+	return res
+}
+
+// imageContentType returns the content type of the given image data
+func imageContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	if strings.HasPrefix(contentType, "image/") {
+		return contentType
+	}
+	if bytes.Contains(data[:minInt(len(data), 1024)], []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return contentType
+}
+
+// processImage returns the given image data resized to the given size with its content type.
+//
+// Only PNG, JPEG and GIF images are resized and images are never enlarged.
+// Other formats, such as SVG or WebP, are returned as is. Images with more pixels
+// than the Web.MaxImagePixels configuration key are not decoded and errImageTooLarge
+// is returned instead.
+func processImage(data []byte, size imageSize) ([]byte, string, error) {
+	contentType := imageContentType(data)
+	if size.isZero() {
+		return data, contentType, nil
+	}
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return data, contentType, nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %s", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels() {
+		return nil, "", errImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %s", err)
+	}
+	bounds := img.Bounds()
+	if size.Crop && size.Width > 0 && size.Height > 0 {
+		bounds = cropBounds(bounds, size.Width, size.Height)
+	}
+	width, height := fitSize(bounds.Dx(), bounds.Dy(), size.Width, size.Height)
+	if bounds == img.Bounds() && width == bounds.Dx() && height == bounds.Dy() {
+		return data, contentType, nil
+	}
+	resized := resizeImage(img, bounds, width, height)
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, resized)
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: imageJPEGQuality})
+	case "image/gif":
+		err = gif.Encode(&buf, resized, nil)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to encode image: %s", err)
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+// cropBounds returns the largest rectangle at the center
+// of bounds that has the ratio of width and height.
+func cropBounds(bounds image.Rectangle, width, height int) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w*height > h*width {
+		newW := h * width / height
+		x0 := bounds.Min.X + (w-newW)/2
+		return image.Rect(x0, bounds.Min.Y, x0+newW, bounds.Max.Y)
+	}
+	newH := w * height / width
+	y0 := bounds.Min.Y + (h-newH)/2
+	return image.Rect(bounds.Min.X, y0, bounds.Max.X, y0+newH)
+}
+
+// fitSize returns the size of an image of w x h pixels scaled down to
+// fit in maxW x maxH, keeping its ratio. Zero max values are ignored.
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && maxW < w {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && maxH < h && float64(maxH)/float64(h) < scale {
+		scale = float64(maxH) / float64(h)
+	}
+	if scale == 1 {
+		return w, h
+	}
+	return maxInt(1, int(float64(w)*scale+0.5)), maxInt(1, int(float64(h)*scale+0.5))
+}
+
+// resizeImage returns the part of img within bounds scaled to width x height pixels.
+//
+// Each pixel of the result is the average of the source pixels it covers,
+// which gives good results when reducing images.
+func resizeImage(img image.Image, bounds image.Rectangle, width, height int) *image.RGBA {
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy0 := bounds.Min.Y + y*bounds.Dy()/height
+		sy1 := maxInt(sy0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			sx0 := bounds.Min.X + x*bounds.Dx()/width
+			sx1 := maxInt(sx0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := res.PixOffset(x, y)
+			res.Pix[i] = uint8(r / n >> 8)
+			res.Pix[i+1] = uint8(g / n >> 8)
+			res.Pix[i+2] = uint8(b / n >> 8)
+			res.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return res
+}
+
+// minInt returns the smallest of a and b
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt returns the largest of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// A resizedImage is an image of the resizedImages cache
+type resizedImage struct {
+	key         string
+	data        []byte
+	contentType string
+}
+
+// resizedImagesCache is a least recently used cache of resized images
+// whose total size in bytes is bounded.
+type resizedImagesCache struct {
+	sync.Mutex
+	size    int
+	bytes   int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// resizedImages caches the images resized by the Image controller.
+//
+// Images are keyed by their source data hash, so that a modified
+// image never hits the variants of its previous version.
+var resizedImages = &resizedImagesCache{
+	size:    resizedImagesCacheSize,
+	entries: make(map[string]*list.Element),
+	order:   list.New(),
+}
+
+// key returns the cache key of the given source image data resized to size
+func (rc *resizedImagesCache) key(data []byte, size imageSize) string {
+	return fmt.Sprintf("%x-%dx%d-%t", sha1.Sum(data), size.Width, size.Height, size.Crop)
+}
+
+// get returns the cached image for the given key if any
+func (rc *resizedImagesCache) get(key string) (*resizedImage, bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	elt, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	rc.order.MoveToFront(elt)
+	return elt.Value.(*resizedImage), true
+}
+
+// add adds the given image to the cache, removing the least recently
+// used images until the cache fits in its size.
+//
+// Images larger than the whole cache are not cached.
+func (rc *resizedImagesCache) add(img *resizedImage) {
+	if len(img.data) > rc.size {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if elt, ok := rc.entries[img.key]; ok {
+		rc.bytes -= len(elt.Value.(*resizedImage).data)
+		elt.Value = img
+		rc.order.MoveToFront(elt)
+	} else {
+		rc.entries[img.key] = rc.order.PushFront(img)
+	}
+	rc.bytes += len(img.data)
+	for rc.bytes > rc.size {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		rc.bytes -= len(oldest.Value.(*resizedImage).data)
+		delete(rc.entries, oldest.Value.(*resizedImage).key)
+	}
+}
+
+// resizedImageData returns the given image data resized to the given size
+// with its content type, using the resizedImages cache.
+func resizedImageData(data []byte, size imageSize) ([]byte, string, error) {
+	if size.isZero() {
+		return data, imageContentType(data), nil
+	}
+	key := resizedImages.key(data, size)
+	if img, ok := resizedImages.get(key); ok {
+		return img.data, img.contentType, nil
+	}
+	res, contentType, err := processImage(data, size)
+	if err != nil {
+		return nil, "", err
+	}
+	resizedImages.add(&resizedImage{key: key, data: res, contentType: contentType})
+	return res, contentType, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+)
+
+// ImportSetFile stores the file uploaded in the 'file' form value
+// of the request in the import wizard given by 'import_id'.
+//
+// The file can then be previewed and imported by calling the
+// 'parse_preview' and 'do' methods of the wizard.
+func ImportSetFile(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	CheckUser(uid)
+	importID, err := strconv.ParseInt(c.PostForm("import_id"), 10, 64)
+	if err != nil {
+		fileError(c, fmt.Errorf("invalid import ID: %s", err))
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		fileError(c, fmt.Errorf("unable to read uploaded file: %s", err))
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		fileError(c, fmt.Errorf("unable to read uploaded file: %s", err))
+		return
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		fileError(c, fmt.Errorf("unable to read uploaded file: %s", err))
+		return
+	}
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		wizard := h.ImportWizard().Search(env, q.ImportWizard().ID().Equals(importID))
+		wizard.EnsureOne()
+		wizard.Write(h.ImportWizard().NewData().
+			SetFile(base64.StdEncoding.EncodeToString(content)).
+			SetFileName(fileHeader.Filename).
+			SetFileType(fileHeader.Header.Get("Content-Type")))
+	})
+	if err != nil {
+		fileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"result": true})
+}
@@ -4,60 +4,300 @@
 package controllers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/gin-gonic/gin/binding"
+	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/server"
 	"github.com/hexya-erp/hexya/src/tools/hweb"
+	"github.com/hexya-erp/pool/h"
+	"github.com/spf13/viper"
 )
 
+// defaultRedirect is the URL to which users are sent after logging in
+// when no redirect is given or when the given redirect is not allowed.
+const defaultRedirect = "/web"
+
+// safeRedirect returns the given redirect URL if it is a path on this server
+// or an absolute URL on a host of the Web.RedirectAllowedHosts configuration
+// key, and defaultRedirect otherwise, so that login and logout links cannot
+// send users to other sites.
+func safeRedirect(redirect string) string {
+	// Browsers read backslashes as slashes, turning '/\host' into '//host'
+	if redirect == "" || strings.ContainsAny(redirect, "\\\r\n\t") {
+		return defaultRedirect
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		return defaultRedirect
+	}
+	if u.Scheme == "" && u.Host == "" && u.User == nil {
+		if strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(redirect, "//") {
+			return redirect
+		}
+		return defaultRedirect
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return defaultRedirect
+	}
+	for _, host := range viper.GetStringSlice("Web.RedirectAllowedHosts") {
+		if strings.EqualFold(u.Host, host) {
+			return redirect
+		}
+	}
+	log.Warn("redirect to a host that is not allowed refused", "redirect", redirect)
+	return defaultRedirect
+}
+
 // LoginGet is called when the client calls the login page
 func LoginGet(c *server.Context) {
-	redirect := c.DefaultQuery("redirect", "/web")
+	redirect := safeRedirect(c.Query("redirect"))
 	if c.Session().Get("uid") != nil {
 		c.Redirect(http.StatusSeeOther, redirect)
 		return
 	}
-	data := FrontendContext
+	renderLogin(c, http.StatusOK, "web.login", hweb.Context{"redirect": redirect})
+}
+
+// renderLogin renders the given login page template with FrontendContext
+// updated with the given values and the session information.
+func renderLogin(c *server.Context, status int, template string, values hweb.Context) {
 	siBytes, err := json.Marshal(GetSessionInfoStruct(c.Session()))
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	data := make(hweb.Context)
+	data.Update(FrontendContext)
+	data.Update(values)
 	data["session_info"] = string(siBytes)
-	c.HTML(http.StatusOK, "web.login", data)
+	data["csrf_token"] = csrfToken(c)
+	c.HTML(status, template, data)
+}
+
+// loginThrottled renders the given login page template with an error and
+// returns true if login attempts for the given login are currently refused
+// because of previous failed attempts.
+func loginThrottled(c *server.Context, template, login string) bool {
+	var wait time.Duration
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		wait = h.LoginAttempt().NewSet(env).CheckLogin(login, c.ClientIP())
+	})
+	if err != nil {
+		c.Error(err)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	log.Warn("login attempt refused because of previous failures", "login", login, "ip", c.ClientIP())
+	renderLogin(c, http.StatusTooManyRequests, template, hweb.Context{
+		"error":    fmt.Sprintf("Too many failed login attempts. Please try again in %s.", wait.Round(time.Second)),
+		"login":    login,
+		"redirect": safeRedirect(c.PostForm("redirect")),
+	})
+	return true
+}
+
+// registerLoginFailure records a failed login attempt for the given login
+func registerLoginFailure(c *server.Context, login string) {
+	log.Info("failed login attempt", "login", login, "ip", c.ClientIP())
+	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		h.LoginAttempt().NewSet(env).RegisterFailure(login, c.ClientIP(), c.Request.UserAgent())
+	})
 }
 
 // LoginPost is called when the client sends credentials
 // from the login page
+//
+// Users who enrolled an authenticator app are then asked for
+// a code by the login TOTP page before being logged in.
 func LoginPost(c *server.Context) {
 	login := c.DefaultPostForm("login", "")
 	secret := c.DefaultPostForm("password", "")
-	uid, err := security.AuthenticationRegistry.Authenticate(login, secret, new(types.Context))
+	redirect := safeRedirect(c.PostForm("redirect"))
+	if loginThrottled(c, "web.login", login) {
+		return
+	}
+	uid, err := security.AuthenticationRegistry.Authenticate(login, secret, types.NewContext().WithKey("interactive", true))
+	if err != nil {
+		registerLoginFailure(c, login)
+		renderLogin(c, http.StatusOK, "web.login", hweb.Context{
+			"error":    "Wrong login or password",
+			"login":    login,
+			"redirect": redirect,
+		})
+		return
+	}
+	var totpEnabled bool
+	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		totpEnabled = h.User().BrowseOne(env, uid).TOTPEnabled()
+	})
 	if err != nil {
-		c.HTML(http.StatusOK, "web.login", FrontendContext.Update(hweb.Context{
-			"error": "Wrong login or password",
-		}))
+		c.Error(err)
 		return
 	}
+	if totpEnabled {
+		sess := c.Session()
+		sess.Clear()
+		sess.Set("totp_uid", uid)
+		sess.Set("totp_login", login)
+		sess.Set("totp_time", time.Now().Unix())
+		sess.Save()
+		renderLogin(c, http.StatusOK, "web.login_totp", hweb.Context{"redirect": redirect})
+		return
+	}
+	logUserIn(c, uid, login, redirect)
+}
 
+// logUserIn opens a new session for the given user and redirects to the given URL
+func logUserIn(c *server.Context, uid int64, login, redirect string) {
+	key, err := newSessionKey()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var sessionID int64
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		h.LoginAttempt().NewSet(env).Sudo().ClearLogin(login)
+		userSessions := h.UserSession().NewSet(env).Sudo()
+		userSessions.RemoveExpiredSessions(sessionIdleTimeout(), sessionLifetime())
+		sessionID = userSessions.OpenSession(h.User().BrowseOne(env, uid), key, c.ClientIP(), c.Request.UserAgent()).ID()
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	sess := c.Session()
+	sess.Clear()
 	sess.Set("uid", uid)
 	sess.Set("login", login)
-	// TODO Manage session_id
-	sess.Set("ID", int64(1))
+	sess.Set("ID", sessionID)
+	sess.Set("key", key)
 	sess.Save()
-	redirect := c.DefaultPostForm("redirect", "/web")
 	c.Redirect(http.StatusSeeOther, redirect)
 }
 
 // LoginRequired is a middleware that redirects to login page
 // non logged in users.
+//
+// JSON-RPC requests get a session expired error instead, so that
+// the web client can ask the user to log in again.
+//
+// JSON-RPC requests can also be authenticated with an API key in an
+// 'Authorization: Bearer <key>' header instead of the session cookie.
 func LoginRequired(c *server.Context) {
-	if c.Session().Get("uid") == nil || c.Session().Get("uid") == 0 {
-		c.Redirect(http.StatusSeeOther, "/web/login")
-		c.Abort()
+	if key, ok := bearerToken(c); ok {
+		apiKeyRequired(c, key)
+		return
+	}
+	if sessionUID(c) != 0 {
+		return
+	}
+	if c.ContentType() == binding.MIMEJSON {
+		sessionExpired(c)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, "/web/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+	c.Abort()
+}
+
+// sessionExpiredErrorData is the data of the JSON-RPC error
+// sent to the web client when its session has expired.
+type sessionExpiredErrorData struct {
+	server.JSONRPCErrorData
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// sessionExpired aborts the current JSON-RPC request with the
+// error that the web client recognises as an expired session.
+func sessionExpired(c *server.Context) {
+	var req server.RequestRPC
+	c.ShouldBindJSON(&req)
+	c.AbortWithStatusJSON(http.StatusOK, server.ResponseError{
+		JsonRPC: "2.0",
+		ID:      req.ID,
+		Error: server.JSONRPCError{
+			Code:    100,
+			Message: "Hexya Session Expired",
+			Data: sessionExpiredErrorData{
+				JSONRPCErrorData: server.JSONRPCErrorData{
+					Arguments:     []string{"Session expired"},
+					ExceptionType: "session_expired",
+				},
+				Name:    "hexya.http.SessionExpiredException",
+				Message: "Session expired",
+			},
+		},
+	})
+}
+
+// sessionIdleTimeout returns the duration of inactivity after which sessions
+// expire, as set by the Web.SessionIdleTimeout configuration key.
+//
+// Zero means that sessions never expire because of inactivity. Since the last
+// activity of a session is written at most once a minute, timeouts shorter
+// than a few minutes are not supported.
+func sessionIdleTimeout() time.Duration {
+	return viper.GetDuration("Web.SessionIdleTimeout")
+}
+
+// sessionLifetime returns the duration after which sessions expire whatever
+// their activity, as set by the Web.SessionLifetime configuration key.
+//
+// Zero means that sessions never expire.
+func sessionLifetime() time.Duration {
+	return viper.GetDuration("Web.SessionLifetime")
+}
+
+// newSessionKey returns a new random session key
+func newSessionKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("unable to generate session key: %s", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// sessionUID returns the ID of the user logged in the session of the request,
+// or 0 if the session is not logged in, has expired or has been revoked.
+//
+// The session is cleared if it has expired or has been revoked, and its
+// last activity is updated otherwise.
+func sessionUID(c *server.Context) int64 {
+	sess := c.Session()
+	uid, ok := sess.Get("uid").(int64)
+	if !ok || uid == 0 {
+		return 0
+	}
+	key, _ := sess.Get("key").(string)
+	var valid bool
+	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		userSession := h.UserSession().NewSet(env).FindSession(key)
+		if userSession.IsEmpty() || userSession.User().ID() != uid {
+			return
+		}
+		if userSession.IsExpired(sessionIdleTimeout(), sessionLifetime()) {
+			userSession.Unlink()
+			return
+		}
+		valid = true
+		userSession.Touch(c.ClientIP())
+	})
+	if !valid {
+		sess.Clear()
+		sess.Save()
+		return 0
 	}
+	return uid
 }
@@ -160,12 +160,19 @@ func RegisterRoutes() {
 	os.Remove(getAssetTempFile(frontendCSSRoute))
 
 	root := controllers.Registry
+	root.AddMiddleWare(CSRFProtected)
 	root.AddController(http.MethodGet, "/", func(c *server.Context) {
 		c.Redirect(http.StatusSeeOther, "/web")
 	})
 	root.AddController(http.MethodGet, "/web/login", LoginGet)
 	root.AddController(http.MethodPost, "/web/login", LoginPost)
+	root.AddController(http.MethodPost, "/web/login/totp", LoginTOTP)
+	root.AddController(http.MethodGet, "/web/reset_password", ResetPasswordGet)
+	root.AddController(http.MethodPost, "/web/reset_password", ResetPasswordPost)
 	root.AddController(http.MethodGet, "/web/binary/company_logo", CompanyLogo)
+	// Image checks the session itself to answer 401 instead of redirecting to the login page
+	root.AddController(http.MethodGet, "/web/image/:model/:id/:field/*any", Image)
+	root.AddController(http.MethodGet, "/web/image", Image)
 	assets := root.AddGroup("/web/assets")
 	{
 		assets.AddController(http.MethodGet, "/common.css", AssetsCommonCSS)
@@ -179,16 +186,24 @@ func RegisterRoutes() {
 	{
 		web.AddMiddleWare(LoginRequired)
 		web.AddController(http.MethodGet, "/", WebClient)
-		web.AddController(http.MethodGet, "/image/:model/:id/:field/*any", Image)
-		web.AddController(http.MethodGet, "/image", Image)
 		web.AddController(http.MethodGet, "/menu/:menu_id", MenuImage)
+		web.AddController(http.MethodGet, "/content", Content)
+		web.AddController(http.MethodPost, "/content", Content)
+		web.AddController(http.MethodGet, "/content/*path", Content)
 
 		sess := web.AddGroup("/session")
 		{
 			sess.AddController(http.MethodPost, "/modules", Modules)
 			sess.AddController(http.MethodPost, "/get_session_info", GetSessionInfo)
 			sess.AddController(http.MethodGet, "/logout", Logout)
-			sess.AddController(http.MethodPost, "/change_password", ChangePassword)
+			sess.AddController(http.MethodPost, "/logout_other_devices", sessionOnly(LogoutOtherDevices))
+			sess.AddController(http.MethodPost, "/change_password", sessionOnly(ChangePassword))
+			sess.AddController(http.MethodPost, "/switch_company", SwitchCompany)
+			sess.AddController(http.MethodPost, "/totp/enroll", sessionOnly(TOTPEnroll))
+			sess.AddController(http.MethodPost, "/totp/confirm", sessionOnly(TOTPConfirm))
+			sess.AddController(http.MethodPost, "/api_key/create", sessionOnly(APIKeyCreate))
+			sess.AddController(http.MethodPost, "/api_key/list", sessionOnly(APIKeyList))
+			sess.AddController(http.MethodPost, "/api_key/revoke", sessionOnly(APIKeyRevoke))
 		}
 
 		proxy := web.AddGroup("/proxy")
@@ -218,6 +233,28 @@ func RegisterRoutes() {
 			action.AddController(http.MethodPost, "/load", ActionLoad)
 			action.AddController(http.MethodPost, "/run", ActionRun)
 		}
+		view := web.AddGroup("/view")
+		{
+			view.AddController(http.MethodPost, "/arch", ViewArch)
+		}
+		export := web.AddGroup("/export")
+		{
+			export.AddController(http.MethodPost, "/formats", ExportFormats)
+			export.AddController(http.MethodPost, "/get_fields", ExportGetFields)
+			export.AddController(http.MethodPost, "/namelist", ExportNameList)
+			export.AddController(http.MethodPost, "/csv", ExportCSV)
+			export.AddController(http.MethodPost, "/xlsx", ExportXLSX)
+		}
+		binary := web.AddGroup("/binary")
+		{
+			binary.AddController(http.MethodPost, "/upload_attachment", UploadAttachment)
+			binary.AddController(http.MethodGet, "/saveas", SaveAs)
+			binary.AddController(http.MethodPost, "/saveas", SaveAs)
+		}
+		imp := web.AddGroup("/import")
+		{
+			imp.AddController(http.MethodPost, "/set_file", ImportSetFile)
+		}
 		menu := web.AddGroup("/menu")
 		{
 			menu.AddController(http.MethodPost, "/load_needaction", MenuLoadNeedaction)
@@ -10,6 +10,7 @@ import (
 	"github.com/hexya-addons/web/odooproxy"
 	"github.com/hexya-addons/web/webtypes"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/spf13/viper"
 )
 
 // MethodAdapters is a map giving the adapter to call for each method
@@ -39,12 +40,18 @@ func checkMethod(method, expected string, args []interface{}, numArgs int) {
 }
 
 // createAdapter adapts json object received from client to Create's FieldMap argument.
+//
+// If the Web.EnforceModifiers configuration key is set, the data is first checked
+// against the readonly and required modifiers of the model's form view.
 func createAdapter(rc *models.RecordCollection, method string, args []interface{}) interface{} {
 	checkMethod(method, "Create", args, 1)
 	data, ok := args[0].(models.RecordData)
 	if !ok {
 		log.Panic("Expected arg for Create method to be RecordData", "argType", fmt.Sprintf("%T", args[0]))
 	}
+	if viper.GetBool("Web.EnforceModifiers") {
+		rc.Call("CheckViewModifiers", data)
+	}
 	pcv := rc.CallMulti("ProcessCreateValues", data)
 	cMap := pcv[0].(models.RecordData)
 	dMap := pcv[1].(models.RecordData)
@@ -55,12 +62,18 @@ func createAdapter(rc *models.RecordCollection, method string, args []interface{
 }
 
 // writeAdapter adapts json object received from client to Write's FieldMap and []FieldNamer argument.
+//
+// If the Web.EnforceModifiers configuration key is set, the data is first checked
+// against the readonly and required modifiers of the model's form view.
 func writeAdapter(rc *models.RecordCollection, method string, args []interface{}) interface{} {
 	checkMethod(method, "Write", args, 1)
 	data, ok := args[0].(models.RecordData)
 	if !ok {
 		log.Panic("Expected arg for Write method to be models.FieldMap", "argType", fmt.Sprintf("%T", args[0]))
 	}
+	if viper.GetBool("Web.EnforceModifiers") {
+		rc.Call("CheckViewModifiers", data)
+	}
 	data = rc.Call("ProcessWriteValues", data).(models.RecordData)
 	res := rc.Call("Write", data)
 	return res
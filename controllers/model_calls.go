@@ -24,6 +24,7 @@ import (
 	"github.com/hexya-addons/web/odooproxy"
 	"github.com/hexya-addons/web/webtypes"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/tools/logging"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
@@ -135,6 +136,9 @@ type CallParams struct {
 	Method string                     `json:"method"`
 	Args   []json.RawMessage          `json:"args"`
 	KWArgs map[string]json.RawMessage `json:"kwargs"`
+	// CompanyIDs are the companies selected in the session, which are used
+	// if the context does not set allowed_company_ids.
+	CompanyIDs []int64 `json:"-"`
 }
 
 // Execute executes a method on an object
@@ -147,7 +151,7 @@ func Execute(uid int64, params CallParams) (res interface{}, rError error) {
 		// Create RecordSet from Environment
 		rs, parms, _ := createRecordCollection(env, params)
 		ctx := extractContext(params)
-		rs = rs.WithNewContext(&ctx)
+		rs = rs.WithNewContext(companyContext(env, &ctx, params.CompanyIDs))
 
 		methodName := odooproxy.ConvertMethodName(params.Method)
 
@@ -323,15 +327,25 @@ func CheckUser(uid int64) {
 	}
 }
 
-// getFieldValue retrieves the given field of the given model and id.
+// getFieldValue retrieves the given binary field of the given model and id.
+//
+// It returns errContentForbidden if the field is not a binary field
+// that the user with the given uid can read.
 func getFieldValue(uid, id int64, model, field string) (res interface{}, rError error) {
 	CheckUser(uid)
+	var forbidden bool
 	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
 		model = odooproxy.ConvertModelName(model)
 		rc := env.Pool(model)
+		if !isReadableField(rc, field, fieldtype.Binary) {
+			forbidden = true
+			return
+		}
 		res = rc.Search(rc.Model().Field(models.ID).Equals(id)).Get(rc.Model().FieldName(field))
 	})
-
+	if rError == nil && forbidden {
+		rError = errContentForbidden
+	}
 	return
 }
 
@@ -365,6 +379,9 @@ type SearchReadParams struct {
 	Model   string         `json:"model"`
 	Offset  int            `json:"offset"`
 	Sort    string         `json:"sort"`
+	// CompanyIDs are the companies selected in the session, which are used
+	// if the context does not set allowed_company_ids.
+	CompanyIDs []int64 `json:"-"`
 }
 
 // SearchRead retrieves database records according to the filters defined in params.
@@ -372,7 +389,7 @@ func SearchRead(uid int64, params SearchReadParams) (res *webtypes.SearchReadRes
 	CheckUser(uid)
 	rError = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
 		model := odooproxy.ConvertModelName(params.Model)
-		rs := env.Pool(model).WithNewContext(&params.Context)
+		rs := env.Pool(model).WithNewContext(companyContext(env, &params.Context, params.CompanyIDs))
 		srp := webtypes.SearchParams{
 			Domain: params.Domain,
 			Fields: params.Fields,
@@ -4,26 +4,142 @@
 package controllers
 
 import (
-	"io/ioutil"
+	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"path"
+	"strings"
 
 	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/spf13/viper"
 )
 
-// Load executes a GET request and returns the values
+// defaultProxyAllowedPaths are the path prefixes that can be loaded through
+// the proxy when the Web.ProxyAllowedPaths configuration key is not set.
+var defaultProxyAllowedPaths = []string{"/static/", "/web/webclient/"}
+
+// defaultProxyMaxSize is the maximum size in bytes of the responses loaded
+// through the proxy when the Web.ProxyMaxSize configuration key is not set.
+const defaultProxyMaxSize = 5 * 1024 * 1024
+
+// errProxyResponseTooLarge is returned by proxyResponseWriter
+// when the response is larger than its maximum size.
+var errProxyResponseTooLarge = errors.New("response too large")
+
+// proxyAllowedPaths returns the path prefixes that can be loaded through the proxy
+func proxyAllowedPaths() []string {
+	if paths := viper.GetStringSlice("Web.ProxyAllowedPaths"); len(paths) > 0 {
+		return paths
+	}
+	return defaultProxyAllowedPaths
+}
+
+// proxyMaxSize returns the maximum size in bytes of the responses loaded through the proxy
+func proxyMaxSize() int {
+	if size := viper.GetInt("Web.ProxyMaxSize"); size > 0 {
+		return size
+	}
+	return defaultProxyMaxSize
+}
+
+// proxyResponseWriter is the http.ResponseWriter of the requests made by
+// the proxy, which keeps the response in memory up to a maximum size.
+type proxyResponseWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	maxSize  int
+	tooLarge bool
+}
+
+// Header returns the headers of the response
+func (w *proxyResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader sets the status of the response
+func (w *proxyResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write appends the given data to the body of the response,
+// unless the body would exceed the maximum size.
+func (w *proxyResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.body.Len()+len(data) > w.maxSize {
+		w.tooLarge = true
+		return 0, errProxyResponseTooLarge
+	}
+	return w.body.Write(data)
+}
+
+// proxyRequestURI returns the request URI of the given path if it is
+// a path on this server starting with one of the allowed prefixes.
+func proxyRequestURI(p string) (string, error) {
+	u, err := url.ParseRequestURI(p)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(p, "//") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	cleanPath := path.Clean(u.Path)
+	for _, prefix := range proxyAllowedPaths() {
+		if strings.HasPrefix(cleanPath, prefix) {
+			u.Path = cleanPath
+			return u.RequestURI(), nil
+		}
+	}
+	return "", fmt.Errorf("path %q is not allowed", p)
+}
+
+// Load executes a GET request on one of the paths allowed by the
+// Web.ProxyAllowedPaths configuration key and returns the response body.
+//
+// The request is served by this server directly, with the cookies and
+// authorization of the caller, so that it gives access to nothing more than
+// what the caller could request itself. Responses larger than the
+// Web.ProxyMaxSize configuration key and non 2xx responses are returned as errors.
 func Load(c *server.Context) {
 	qwebParams := struct {
 		Path string `json:"path"`
 	}{}
 	c.BindRPCParams(&qwebParams)
-	path, _ := url.ParseRequestURI(qwebParams.Path)
-	resp, err := c.HTTPGet(path.RequestURI())
+	uri, err := proxyRequestURI(qwebParams.Path)
+	if err != nil {
+		log.Warn("proxy request refused", "path", qwebParams.Path, "error", err, "ip", c.ClientIP())
+		c.RPC(http.StatusOK, nil, exceptions.UserError{Message: fmt.Sprintf("Unable to load %s: %s", qwebParams.Path, err)})
+		return
+	}
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
 	if err != nil {
 		c.AbortWithError(http.StatusBadRequest, err)
 		return
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	c.RPC(http.StatusOK, string(body))
+	req = req.WithContext(c.Request.Context())
+	req.Host = c.Request.Host
+	req.RemoteAddr = c.Request.RemoteAddr
+	for _, header := range []string{"Cookie", "Authorization", "Accept-Language", "User-Agent"} {
+		if value := c.GetHeader(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	resp := &proxyResponseWriter{header: make(http.Header), maxSize: proxyMaxSize()}
+	server.GetServer().ServeHTTP(resp, req)
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	switch {
+	case resp.tooLarge:
+		err = fmt.Errorf("the response is larger than %d bytes", resp.maxSize)
+	case resp.status < 200 || resp.status > 299:
+		err = fmt.Errorf("the server answered %d %s", resp.status, http.StatusText(resp.status))
+	}
+	if err != nil {
+		c.RPC(http.StatusOK, nil, exceptions.UserError{Message: fmt.Sprintf("Unable to load %s: %s", qwebParams.Path, err)})
+		return
+	}
+	c.RPC(http.StatusOK, resp.body.String())
 }
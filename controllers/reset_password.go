@@ -0,0 +1,73 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/exceptions"
+	"github.com/hexya-erp/hexya/src/tools/hweb"
+	"github.com/hexya-erp/pool/h"
+)
+
+// ResetPasswordGet is called when the client calls the reset password page,
+// either to request a reset link or, with the token of a link, to choose
+// a new password.
+func ResetPasswordGet(c *server.Context) {
+	renderLogin(c, http.StatusOK, "web.reset_password", hweb.Context{
+		"token": c.Query("token"),
+	})
+}
+
+// ResetPasswordPost is called when the client sends the reset password form.
+//
+// Without token, a reset link is sent to the user with the given login. With
+// the token of a reset link, the password of its user is changed.
+func ResetPasswordPost(c *server.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		login := c.PostForm("login")
+		err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.User().NewSet(env).RequestPasswordReset(login, c.ClientIP())
+		})
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		// The same message is shown for unknown logins
+		renderLogin(c, http.StatusOK, "web.login", hweb.Context{
+			"message": "If this login exists, a link to reset its password has been sent.",
+			"login":   login,
+		})
+		return
+	}
+	if c.PostForm("new_password") != c.PostForm("confirm_password") {
+		renderLogin(c, http.StatusOK, "web.reset_password", hweb.Context{
+			"error": "The new password and its confirmation must be identical.",
+			"token": token,
+		})
+		return
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		h.User().NewSet(env).ResetPassword(token, c.PostForm("new_password"))
+	})
+	if err != nil {
+		userError, ok := err.(exceptions.UserError)
+		if !ok {
+			c.Error(err)
+			return
+		}
+		renderLogin(c, http.StatusOK, "web.reset_password", hweb.Context{
+			"error": userError.Message,
+			"token": token,
+		})
+		return
+	}
+	renderLogin(c, http.StatusOK, "web.login", hweb.Context{
+		"message": "Your password has been changed. You can now log in.",
+	})
+}
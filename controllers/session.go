@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"github.com/hexya-addons/base"
 	"github.com/hexya-addons/web/webtypes"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -43,6 +44,7 @@ type SessionInfo struct {
 	ShowEffect               bool                   `json:"show_effect"`
 	DisplaySwitchCompanyMenu bool                   `json:"display_switch_company_menu"`
 	CacheHashes              map[string]string      `json:"cache_hashes"`
+	MaxFileUploadSize        int64                  `json:"max_file_upload_size"`
 }
 
 // GetSessionInfoStruct returns a struct with information about the given session
@@ -52,40 +54,41 @@ func GetSessionInfoStruct(sess sessions.Session) *SessionInfo {
 		companyID     int64
 		userCompanies UserCompanies
 		userName      string
+		switchMenu    bool
 	)
 	if sess.Get("uid") != nil {
 		models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
 			user := h.User().Search(env, q.User().ID().Equals(sess.Get("uid").(int64)))
-			userContext = user.ContextGet()
-			companyID = user.Company().ID()
+			companyIDs := validCompanyIDs(user, sessionCompanyIDs(sess))
+			companyID = companyIDs[0]
+			userContext = user.ContextGet().
+				WithKey("company_id", companyID).
+				WithKey("allowed_company_ids", companyIDs)
 			userName = user.Name()
-			userCompanies = UserCompanies{
-				CurrentCompany: webtypes.RecordIDWithName{
-					ID:   companyID,
-					Name: user.Company().Name(),
-				},
-			}
-			for _, company := range user.Companies().Records() {
-				userCompanies.AllowedCompanies = append(userCompanies.AllowedCompanies, webtypes.RecordIDWithName{
+			for _, company := range h.Company().Browse(env, userCompanyIDs(user)).Records() {
+				rec := webtypes.RecordIDWithName{
 					ID:   company.ID(),
 					Name: company.Name(),
-				})
+				}
+				if company.ID() == companyID {
+					userCompanies.CurrentCompany = rec
+				}
+				userCompanies.AllowedCompanies = append(userCompanies.AllowedCompanies, rec)
 			}
+			switchMenu = user.HasGroup(base.GroupMultiCompany.ID()) && len(userCompanies.AllowedCompanies) > 1
 		})
 		return &SessionInfo{
-			SessionID:     sess.Get("ID").(int64),
-			UID:           sess.Get("uid").(int64),
-			UserContext:   userContext.ToMap(),
-			DB:            viper.GetString("DB.Name"),
-			UserName:      sess.Get("login").(string),
-			CompanyID:     companyID,
-			Name:          userName,
-			UserCompanies: userCompanies,
-			CacheHashes: map[string]string{
-				"load_menus":   "1",
-				"qweb":         "2",
-				"translations": "3",
-			},
+			SessionID:                sess.Get("ID").(int64),
+			UID:                      sess.Get("uid").(int64),
+			UserContext:              userContext.ToMap(),
+			DB:                       viper.GetString("DB.Name"),
+			UserName:                 sess.Get("login").(string),
+			CompanyID:                companyID,
+			Name:                     userName,
+			UserCompanies:            userCompanies,
+			DisplaySwitchCompanyMenu: switchMenu,
+			MaxFileUploadSize:        maxUploadSize(),
+			CacheHashes:              cacheHashes(sess.Get("uid").(int64), userContext.GetString("lang")),
 		}
 	}
 	return nil
@@ -108,14 +111,32 @@ func Modules(c *server.Context) {
 // Logout the current user and redirect to login page
 func Logout(c *server.Context) {
 	sess := c.Session()
-	sess.Delete("uid")
-	sess.Delete("ID")
-	sess.Delete("login")
+	if key, ok := sess.Get("key").(string); ok {
+		models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.UserSession().NewSet(env).FindSession(key).Unlink()
+		})
+	}
+	sess.Clear()
 	sess.Save()
-	redirect := c.DefaultQuery("redirect", "/web/login")
+	redirect := "/web/login"
+	if r, ok := c.GetQuery("redirect"); ok {
+		redirect = safeRedirect(r)
+	}
 	c.Redirect(http.StatusSeeOther, redirect)
 }
 
+// LogoutOtherDevices revokes all the sessions of the current user except the
+// current one and returns the number of revoked sessions.
+func LogoutOtherDevices(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	key, _ := c.Session().Get("key").(string)
+	var res int
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		res = h.UserSession().NewSet(env).RevokeOtherSessions(key)
+	})
+	c.RPC(http.StatusOK, res, err)
+}
+
 // ChangePasswordData is the params format passed to ChangePassword controller
 type ChangePasswordData struct {
 	Fields []struct {
@@ -0,0 +1,122 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/hexya-addons/web/qrcode"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/tools/hweb"
+	"github.com/hexya-erp/pool/h"
+)
+
+// totpLoginTimeout is the time given to users to enter their
+// authentication code after having entered their password.
+const totpLoginTimeout = 5 * time.Minute
+
+// totpQRCodeScale is the number of pixels per module of enrollment QR codes
+const totpQRCodeScale = 4
+
+// LoginTOTP is called when the client sends the authentication code of its
+// authenticator app from the login TOTP page, after having sent its password.
+func LoginTOTP(c *server.Context) {
+	sess := c.Session()
+	uid, _ := sess.Get("totp_uid").(int64)
+	login, _ := sess.Get("totp_login").(string)
+	started, _ := sess.Get("totp_time").(int64)
+	redirect := safeRedirect(c.PostForm("redirect"))
+	if uid == 0 || time.Since(time.Unix(started, 0)) > totpLoginTimeout {
+		sess.Clear()
+		sess.Save()
+		renderLogin(c, http.StatusOK, "web.login", hweb.Context{
+			"error":    "Your login has expired. Please log in again.",
+			"redirect": redirect,
+		})
+		return
+	}
+	if loginThrottled(c, "web.login_totp", login) {
+		return
+	}
+	var valid bool
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		valid = h.User().BrowseOne(env, uid).TOTPVerify(c.PostForm("totp_code"))
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !valid {
+		registerLoginFailure(c, login)
+		renderLogin(c, http.StatusOK, "web.login_totp", hweb.Context{
+			"error":    "Invalid authentication code",
+			"redirect": redirect,
+		})
+		return
+	}
+	logUserIn(c, uid, login, redirect)
+}
+
+// TOTPEnrollment is the response of the TOTPEnroll controller
+type TOTPEnrollment struct {
+	URI string `json:"uri"`
+	// QRCode is the URI encoded as a QR code in a PNG data URL
+	QRCode string `json:"qr_code"`
+}
+
+// TOTPEnroll starts the enrollment of an authenticator app for the current
+// user and returns the URI and QR code to be scanned by the app.
+func TOTPEnroll(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	var res TOTPEnrollment
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		res.URI = h.User().NewSet(env).CurrentUser().TOTPEnroll()
+	})
+	if err != nil {
+		c.RPC(http.StatusOK, nil, err)
+		return
+	}
+	res.QRCode, err = qrCodeDataURL(res.URI)
+	if err != nil {
+		// The URI can still be entered manually in the app
+		log.Warn("unable to generate TOTP QR code", "error", err)
+	}
+	c.RPC(http.StatusOK, res)
+}
+
+// qrCodeDataURL returns the given text encoded as a QR code in a PNG data URL
+func qrCodeDataURL(text string) (string, error) {
+	qr, err := qrcode.Encode([]byte(text))
+	if err != nil {
+		return "", err
+	}
+	png, err := qr.PNG(totpQRCodeScale)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// TOTPConfirmParams are the params of the TOTPConfirm controller
+type TOTPConfirmParams struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirm enables two-factor authentication for the current user if
+// the given code of its authenticator app is valid, and returns the user's
+// recovery codes.
+func TOTPConfirm(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	var params TOTPConfirmParams
+	c.BindRPCParams(&params)
+	var res []string
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		res = h.User().NewSet(env).CurrentUser().TOTPConfirm(params.Code)
+	})
+	c.RPC(http.StatusOK, res, err)
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/views"
+)
+
+// ViewArch returns the arch of the given view with all inheritance applied,
+// together with the list of view definitions that contributed to it.
+func ViewArch(c *server.Context) {
+	uid := c.Session().Get("uid").(int64)
+	params := struct {
+		ViewID  string         `json:"view_id"`
+		Context *types.Context `json:"context"`
+	}{}
+	c.BindRPCParams(&params)
+	view := views.Registry.GetByID(params.ViewID)
+	if view == nil {
+		log.Panic("Unknown view", "view", params.ViewID)
+	}
+	viewIDJSON, _ := json.Marshal(params.ViewID)
+	kwargs := make(map[string]json.RawMessage)
+	if params.Context != nil {
+		kwargs["context"], _ = json.Marshal(params.Context)
+	}
+	res, err := Execute(uid, CallParams{
+		Model:      view.Model,
+		Method:     "get_view_arch",
+		Args:       []json.RawMessage{viewIDJSON},
+		KWArgs:     kwargs,
+		CompanyIDs: sessionCompanyIDs(c.Session()),
+	})
+	c.RPC(http.StatusOK, res, err)
+}
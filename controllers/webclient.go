@@ -13,9 +13,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hexya-addons/web/scripts"
 	"github.com/hexya-erp/hexya/src/actions"
-	"github.com/hexya-erp/hexya/src/i18n"
 	"github.com/hexya-erp/hexya/src/menus"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
@@ -23,7 +21,6 @@ import (
 	"github.com/hexya-erp/hexya/src/tools"
 	"github.com/hexya-erp/hexya/src/tools/b64image"
 	"github.com/hexya-erp/hexya/src/tools/hweb"
-	"github.com/hexya-erp/hexya/src/tools/xmlutils"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/q"
 )
@@ -31,8 +28,11 @@ import (
 // QWeb returns a concatenation of all client qweb templates
 func QWeb(c *server.Context) {
 	mods := strings.Split(c.Query("mods"), ",")
-	fileNames := tools.ListStaticFiles(server.ResourceDir, "src/xml", mods, true)
-	res, _, err := xmlutils.ConcatXML(fileNames)
+	hash := qwebHash(server.Modules.Names())
+	if isUniqueNotModified(c, hash, computeETag(hash, c.Query("mods"))) {
+		return
+	}
+	res, err := qwebData(mods)
 	if err != nil {
 		c.Error(fmt.Errorf("error while generating client side QWeb: %s", err.Error()))
 	}
@@ -42,28 +42,21 @@ func QWeb(c *server.Context) {
 // Translations returns data about the current language
 func Translations(c *server.Context) {
 	lang := c.Query("lang")
-	res := gin.H{
-		"lang_parameters": i18n.GetLocale(lang),
-		"modules":         scripts.ListModuleTranslations(lang),
-		"multi_lang":      true,
+	hash := translationsHash(lang, server.Modules.Names())
+	if isUniqueNotModified(c, hash, computeETag(hash)) {
+		return
 	}
-	c.JSON(http.StatusOK, res)
+	c.JSON(http.StatusOK, translationsData(lang))
 }
 
 // LoadMenus returns the menus of the application as JSON
 func LoadMenus(c *server.Context) {
-	lang := GetSessionInfoStruct(c.Session()).UserContext["lang"].(string)
-	var allRootMenuIds []int64
-	for _, menu := range menus.Registry.All() {
-		allRootMenuIds = append(allRootMenuIds, menu.ID)
-	}
-	rootMenu := gin.H{
-		"name":         "root",
-		"parent_id":    parentTuple{"-1", ""},
-		"children":     getMenuTree(menus.Registry.Menus, lang),
-		"all_menu_ids": allRootMenuIds,
+	info := GetSessionInfoStruct(c.Session())
+	hash := info.CacheHashes["load_menus"]
+	if isUniqueNotModified(c, hash, computeETag(hash)) {
+		return
 	}
-	c.JSON(http.StatusOK, rootMenu)
+	c.JSON(http.StatusOK, menusData(info.UserContext["lang"].(string)))
 }
 
 // CSSList returns the list of CSS files
@@ -214,6 +207,7 @@ func WebClient(c *server.Context) {
 	data := hweb.Context{
 		"modules":            string(modBytes),
 		"session_info":       string(siBytes),
+		"csrf_token":         csrfToken(c),
 		"debug":              "",
 		"commonCompiledCSS":  commonCSSRoute,
 		"backendCompiledCSS": backendCSSRoute,
@@ -0,0 +1,131 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xlsxStaticParts are the parts of an XLSX file with a single worksheet
+// that do not depend on the data.
+var xlsxStaticParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>
+</styleSheet>`},
+}
+
+// xlsxInvalidSheetNameChars are the characters that are not allowed in a worksheet name
+const xlsxInvalidSheetNameChars = `[]:*?/\`
+
+// writeXLSX writes to w an XLSX file with a single worksheet with the given
+// name, holding the given headers in bold followed by the given rows.
+//
+// All values are written as strings.
+func writeXLSX(w io.Writer, sheetName string, headers []string, rows [][]string) error {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.content); err != nil {
+			return err
+		}
+	}
+
+	sheetName = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(xlsxInvalidSheetNameChars, r) {
+			return '_'
+		}
+		return r
+	}, sheetName)
+	if len([]rune(sheetName)) > 31 {
+		sheetName = string([]rune(sheetName)[:31])
+	}
+	ww, err := zw.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(ww, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`, xlsxEscape(sheetName))
+
+	sw, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	writeXLSXRow(&buf, 1, headers, 1)
+	for i, row := range rows {
+		writeXLSXRow(&buf, i+2, row, 0)
+	}
+	buf.WriteString(`</sheetData></worksheet>`)
+	if _, err := buf.WriteTo(sw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeXLSXRow writes the given values as inline string cells
+// of the worksheet row with the given number and style.
+func writeXLSXRow(buf *bytes.Buffer, num int, values []string, style int) {
+	fmt.Fprintf(buf, `<row r="%d">`, num)
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		fmt.Fprintf(buf, `<c r="%s%d" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`,
+			xlsxColumnName(i), num, style, xlsxEscape(value))
+	}
+	buf.WriteString(`</row>`)
+}
+
+// xlsxColumnName returns the letters of the column with the given index (starting at 0)
+func xlsxColumnName(index int) string {
+	var res string
+	for index++; index > 0; index = (index - 1) / 26 {
+		res = string(rune('A'+(index-1)%26)) + res
+	}
+	return res
+}
+
+// xlsxEscape returns the given string escaped for XML content and attributes
+func xlsxEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// ExportData returns the values of the given fields for the records of this
+// RecordSet as strings, suitable for writing in a CSV or XLSX file.
+//
+// Fields are paths through relations, separated by slashes or dots (e.g.
+// "partner_id/name" or "line_ids.product_id.default_code"). The special paths
+// "id" and ".id" give the external ID and the database ID of the records.
+// A relation field without sub path gives the display name of its records.
+//
+// Each record gives one row, followed by an additional row for each record
+// of its x2many relations after the first one. In additional rows, only the
+// columns of the relation are set.
+//
+// Selection fields are exported with their label, unless the 'import_compat'
+// context key is set, in which case the selection key is exported.
+func commonMixin_ExportData(rs m.CommonMixinSet, fields []string) [][]string {
+	paths := make([][]string, len(fields))
+	for i, f := range fields {
+		paths[i] = exportPath(f)
+	}
+	return exportRows(rs.Collection(), paths)
+}
+
+// exportPath splits the given field path into field names.
+func exportPath(field string) []string {
+	var res []string
+	for _, token := range strings.Split(field, "/") {
+		if token == ".id" {
+			res = append(res, token)
+			continue
+		}
+		res = append(res, strings.Split(token, ".")...)
+	}
+	return res
+}
+
+// exportRows returns the rows of data for the given paths of the records of rc.
+//
+// All paths with the same x2many relation are exported together, so that
+// the values of a sub-record are on the same row. The sub-records of different
+// x2many relations share the rows of their record.
+func exportRows(rc *models.RecordCollection, paths [][]string) [][]string {
+	rc.Call("CheckAccessRights", webtypes.CheckAccessRightsArgs{Operation: "read", RaiseException: true})
+	var fNames models.FieldNames
+	for _, path := range paths {
+		if path[0] == "id" || path[0] == ".id" {
+			continue
+		}
+		fNames = append(fNames, rc.Model().FieldName(path[0]))
+	}
+	fInfos := rc.Call("FieldsGet", models.FieldsGetArgs{Fields: fNames}).(map[string]*models.FieldInfo)
+	for _, fName := range fNames {
+		if _, ok := fInfos[fName.JSON()]; !ok {
+			// FieldsGet does not describe the fields the user cannot access
+			log.Panic(rc.T("Field %s of %s cannot be exported", fName.JSON(), rc.ModelName()))
+		}
+	}
+	importCompat := rc.Env().Context().GetBool("import_compat")
+
+	var res [][]string
+	for _, rec := range rc.Records() {
+		current := make([]string, len(paths))
+		lines := [][]string{current}
+		done := make([]bool, len(paths))
+		for i, path := range paths {
+			if done[i] {
+				continue
+			}
+			done[i] = true
+			switch path[0] {
+			case "id":
+				current[i] = rec.Get(rec.Model().FieldName("HexyaExternalID")).(string)
+				continue
+			case ".id":
+				current[i] = strconv.FormatInt(rec.Get(models.ID).(int64), 10)
+				continue
+			}
+			value := rec.Get(rec.Model().FieldName(path[0]))
+			relRS, isRelation := value.(models.RecordSet)
+			switch {
+			case !isRelation:
+				current[i] = formatExportValue(value, fInfos[rec.Model().FieldName(path[0]).JSON()], importCompat)
+				continue
+			case len(path) == 1:
+				var names []string
+				for _, relRec := range relRS.Collection().Records() {
+					names = append(names, relRec.Call("NameGet").(string))
+				}
+				current[i] = strings.Join(names, ",")
+				continue
+			}
+			var (
+				subPaths [][]string
+				cols     []int
+			)
+			for j := i; j < len(paths); j++ {
+				if paths[j][0] != path[0] || len(paths[j]) == 1 {
+					continue
+				}
+				subPaths = append(subPaths, paths[j][1:])
+				cols = append(cols, j)
+				done[j] = true
+			}
+			for k, subLine := range exportRows(relRS.Collection(), subPaths) {
+				// Rows of another x2many relation are reused before adding new ones
+				if k == len(lines) {
+					lines = append(lines, make([]string, len(paths)))
+				}
+				line := lines[k]
+				for c, col := range cols {
+					line[col] = subLine[c]
+				}
+			}
+		}
+		res = append(res, lines...)
+	}
+	return res
+}
+
+// formatExportValue returns the given field value as a string for export.
+func formatExportValue(value interface{}, fInfo *models.FieldInfo, importCompat bool) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if fInfo != nil && fInfo.Type == fieldtype.Selection && !importCompat {
+			if label, ok := fInfo.Selection[v]; ok {
+				return label
+			}
+		}
+		return v
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case dates.Date:
+		if v.IsZero() {
+			return ""
+		}
+		return v.String()
+	case dates.DateTime:
+		if v.IsZero() {
+			return ""
+		}
+		return v.String()
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func init() {
+	h.CommonMixin().NewMethod("ExportData", commonMixin_ExportData)
+}
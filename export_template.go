@@ -0,0 +1,138 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"strings"
+
+	"github.com/hexya-addons/web/odooproxy"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+var fields_ExportTemplate = map[string]models.FieldDefinition{
+	"Name":     fields.Char{String: "Export Name", Required: true},
+	"ResModel": fields.Char{String: "Model", Required: true, Index: true, JSON: "resource"},
+	"User": fields.Many2One{RelationModel: h.User(), OnDelete: models.Cascade,
+		Default: func(env models.Environment) interface{} {
+			return h.User().Search(env, q.User().ID().Equals(env.Uid()))
+		}, Help: `The user this export template is private to. When left empty the template is public and available to all users.`},
+	"ExportFields": fields.One2Many{RelationModel: h.ExportTemplateLine(), ReverseFK: "Template",
+		String: "Export Fields", JSON: "export_fields", Copy: true},
+}
+
+var fields_ExportTemplateLine = map[string]models.FieldDefinition{
+	"Name": fields.Char{String: "Field Path", Required: true},
+	"Template": fields.Many2One{RelationModel: h.ExportTemplate(), OnDelete: models.Cascade,
+		Required: true, Index: true, JSON: "export_id"},
+}
+
+// GetTemplates returns the export templates of the given model for the current user,
+// that is the templates private to the user and the public ones.
+func exportTemplate_GetTemplates(rs m.ExportTemplateSet, modelName string) []m.ExportTemplateData {
+	modelName = odooproxy.ConvertModelName(modelName)
+	templates := h.ExportTemplate().Search(rs.Env(), q.ExportTemplate().ResModel().Equals(modelName).
+		AndCond(q.ExportTemplate().UserFilteredOn(q.User().ID().Equals(rs.Env().Uid())).
+			Or().User().IsNull()))
+	return templates.All()
+}
+
+// SaveTemplate saves the given field paths as a private export template of the given
+// model for the current user. If the user already has a template with the same name
+// (case insensitive) for this model, its fields are replaced.
+func exportTemplate_SaveTemplate(rs m.ExportTemplateSet, name, modelName string, fieldPaths []string) m.ExportTemplateSet {
+	modelName = odooproxy.ConvertModelName(modelName)
+	user := h.User().BrowseOne(rs.Env(), rs.Env().Uid())
+	template := h.ExportTemplate().NewSet(rs.Env())
+	for _, tmpl := range rs.GetTemplates(modelName) {
+		if strings.ToLower(tmpl.Name()) != strings.ToLower(name) || !tmpl.User().Equals(user) {
+			continue
+		}
+		template = h.ExportTemplate().BrowseOne(rs.Env(), tmpl.ID())
+		break
+	}
+	if template.IsEmpty() {
+		template = h.ExportTemplate().Create(rs.Env(), h.ExportTemplate().NewData().
+			SetName(name).
+			SetResModel(modelName).
+			SetUser(user))
+	}
+	template.ExportFields().Unlink()
+	for _, path := range fieldPaths {
+		h.ExportTemplateLine().Create(rs.Env(), h.ExportTemplateLine().NewData().
+			SetName(path).
+			SetTemplate(template))
+	}
+	return template
+}
+
+// DeleteTemplate deletes the export templates of this RecordSet.
+//
+// As for GetTemplates, users can only delete their own templates and the public ones.
+func exportTemplate_DeleteTemplate(rs m.ExportTemplateSet) bool {
+	for _, tmpl := range rs.Records() {
+		if tmpl.User().IsNotEmpty() && tmpl.User().ID() != rs.Env().Uid() {
+			log.Panic(rs.T("You cannot delete export templates of other users"), "template", tmpl.ID())
+		}
+	}
+	rs.Unlink()
+	return true
+}
+
+// FieldList returns the field paths of this export template, with their labels
+// computed from the field descriptions of the model.
+func exportTemplate_FieldList(rs m.ExportTemplateSet) []map[string]string {
+	rs.EnsureOne()
+	var res []map[string]string
+	model := rs.Env().Pool(rs.ResModel())
+	for _, line := range rs.ExportFields().Records() {
+		res = append(res, map[string]string{
+			"name":  line.Name(),
+			"label": exportPathLabel(model, exportPath(line.Name())),
+		})
+	}
+	return res
+}
+
+// exportPathLabel returns the label of the field path given by the field names
+// of path, starting from the given model, e.g. "Partner/Name".
+func exportPathLabel(rc *models.RecordCollection, path []string) string {
+	var labels []string
+	for _, fName := range path {
+		switch fName {
+		case "id":
+			labels = append(labels, "External ID")
+			continue
+		case ".id":
+			labels = append(labels, "ID")
+			continue
+		}
+		fInfo, ok := rc.Call("FieldsGet", models.FieldsGetArgs{}).(map[string]*models.FieldInfo)[fName]
+		if !ok {
+			labels = append(labels, fName)
+			continue
+		}
+		labels = append(labels, fInfo.String)
+		if fInfo.Relation != "" {
+			rc = rc.Env().Pool(fInfo.Relation)
+		}
+	}
+	return strings.Join(labels, "/")
+}
+
+func init() {
+	models.NewModel("ExportTemplate")
+	h.ExportTemplate().AddFields(fields_ExportTemplate)
+	h.ExportTemplate().AddSQLConstraint("name_model_uid_unique", "unique (name, resource, user_id)", "Export template names must be unique")
+	h.ExportTemplate().NewMethod("GetTemplates", exportTemplate_GetTemplates)
+	h.ExportTemplate().NewMethod("SaveTemplate", exportTemplate_SaveTemplate)
+	h.ExportTemplate().NewMethod("DeleteTemplate", exportTemplate_DeleteTemplate)
+	h.ExportTemplate().NewMethod("FieldList", exportTemplate_FieldList)
+
+	models.NewModel("ExportTemplateLine")
+	h.ExportTemplateLine().AddFields(fields_ExportTemplateLine)
+}
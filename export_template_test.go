@@ -0,0 +1,76 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportTemplates(t *testing.T) {
+	Convey("Testing export templates", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demoUser := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			adminUser := h.User().Search(env, q.User().ID().Equals(security.SuperUserID))
+			Convey("Saving a template should create a private template with its fields", func() {
+				tmpl := h.ExportTemplate().NewSet(env).Sudo(demoUser.ID()).
+					SaveTemplate("Monthly", "res.partner", []string{"name", "country_id/name"})
+				So(tmpl.Len(), ShouldEqual, 1)
+				So(tmpl.ResModel(), ShouldEqual, "Partner")
+				So(tmpl.User().Equals(demoUser), ShouldBeTrue)
+				So(tmpl.FieldList(), ShouldResemble, []map[string]string{
+					{"name": "name", "label": "Name"},
+					{"name": "country_id/name", "label": "Country/Country Name"},
+				})
+				Convey("Saving again with the same name should replace the fields", func() {
+					tmpl2 := h.ExportTemplate().NewSet(env).Sudo(demoUser.ID()).
+						SaveTemplate("monthly", "Partner", []string{"email"})
+					So(tmpl2.Equals(tmpl), ShouldBeTrue)
+					So(tmpl.ExportFields().Len(), ShouldEqual, 1)
+					So(tmpl.ExportFields().Name(), ShouldEqual, "email")
+				})
+			})
+			Convey("Users should only get their own and public templates", func() {
+				h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("a").
+					SetUser(nil).
+					SetResModel("Partner"))
+				h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("b").
+					SetUser(adminUser).
+					SetResModel("Partner"))
+				h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("c").
+					SetUser(demoUser).
+					SetResModel("Partner"))
+				h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("d").
+					SetUser(demoUser).
+					SetResModel("User"))
+				templates := h.ExportTemplate().NewSet(env).Sudo(demoUser.ID()).GetTemplates("Partner")
+				So(templates, ShouldHaveLength, 2)
+				So(templates[0].Name(), ShouldEqual, "a")
+				So(templates[1].Name(), ShouldEqual, "c")
+			})
+			Convey("Users should not delete templates of other users", func() {
+				public := h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("a").
+					SetUser(nil).
+					SetResModel("Partner"))
+				private := h.ExportTemplate().Create(env, h.ExportTemplate().NewData().
+					SetName("b").
+					SetUser(adminUser).
+					SetResModel("Partner"))
+				So(func() { private.Sudo(demoUser.ID()).DeleteTemplate() }, ShouldPanic)
+				So(public.Sudo(demoUser.ID()).DeleteTemplate(), ShouldBeTrue)
+				So(h.ExportTemplate().Search(env, q.ExportTemplate().ResModel().Equals("Partner")).Len(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExportData(t *testing.T) {
+	Convey("Testing data export", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			belgium := h.Country().NewSet(env).GetRecord("base_be")
+			corp := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Export Corp").
+				SetIsCompany(true).
+				SetCountry(belgium))
+			h.Partner().Create(env, h.Partner().NewData().
+				SetName("Alice").
+				SetEmail("alice@example.com").
+				SetParent(corp))
+			h.Partner().Create(env, h.Partner().NewData().
+				SetName("Bob").
+				SetParent(corp))
+			Convey("Simple fields and many2one paths should give one row per record", func() {
+				rows := corp.ExportData([]string{"name", "country_id/name", "country_id", ".id", "id"})
+				So(rows, ShouldHaveLength, 1)
+				So(rows[0], ShouldResemble, []string{"Export Corp", "Belgium", "Belgium",
+					strconv.FormatInt(corp.ID(), 10), corp.HexyaExternalID()})
+			})
+			Convey("x2many paths should give one row per sub-record", func() {
+				rows := corp.ExportData([]string{"name", "children_ids/name", "country_id/name", "children_ids.email"})
+				So(rows, ShouldHaveLength, 2)
+				So(rows[0], ShouldResemble, []string{"Export Corp", "Alice", "Belgium", "alice@example.com"})
+				So(rows[1], ShouldResemble, []string{"", "Bob", "", ""})
+			})
+			Convey("Different x2many paths should share the rows of their record", func() {
+				for _, name := range []string{"Tag 1", "Tag 2", "Tag 3"} {
+					corp.SetCategories(corp.Categories().Union(
+						h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName(name))))
+				}
+				rows := corp.ExportData([]string{"name", "children_ids/name", "category_ids/name"})
+				So(rows, ShouldHaveLength, 3)
+				So(rows[0], ShouldResemble, []string{"Export Corp", "Alice", "Tag 1"})
+				So(rows[1], ShouldResemble, []string{"", "Bob", "Tag 2"})
+				So(rows[2], ShouldResemble, []string{"", "", "Tag 3"})
+			})
+			Convey("Records without sub-records should have empty columns", func() {
+				alice := h.Partner().Search(env, q.Partner().Name().Equals("Alice"))
+				rows := alice.ExportData([]string{"name", "children_ids/name", "parent_id/name"})
+				So(rows, ShouldHaveLength, 1)
+				So(rows[0], ShouldResemble, []string{"Alice", "", "Export Corp"})
+			})
+			Convey("Selection fields should be exported with their label unless import compatible", func() {
+				demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+				demo.SetChatterPosition("sided")
+				So(demo.ExportData([]string{"chatter_position"}), ShouldResemble, [][]string{{"Sided"}})
+				So(demo.WithContext("import_compat", true).ExportData([]string{"chatter_position"}),
+					ShouldResemble, [][]string{{"sided"}})
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// restrictedFields holds, by model name and field JSON name, the groups whose
+// members can access fields that are restricted with restrictFieldAccess.
+var restrictedFields = make(map[string]map[string][]*security.Group)
+
+// restrictFieldAccess restricts the access to the given fields of the given model
+// to the superuser and to the members of the given groups.
+//
+// Restricted fields are not returned by FieldsGet and Read for other users and
+// they cannot search on them. Since the client data is processed with the result
+// of FieldsGet, they cannot write them either. Exports and binary content routes
+// also rely on FieldsGet and refuse restricted fields.
+func restrictFieldAccess(model string, fields []models.FieldName, groups ...*security.Group) {
+	if restrictedFields[model] == nil {
+		restrictedFields[model] = make(map[string][]*security.Group)
+	}
+	for _, f := range fields {
+		restrictedFields[model][f.JSON()] = groups
+	}
+}
+
+// canAccessField returns true if the user of the given environment
+// can access the field with the given JSON name of the given model.
+func canAccessField(env models.Environment, model, field string) bool {
+	groups, restricted := restrictedFields[model][field]
+	if !restricted || env.Uid() == security.SuperUserID {
+		return true
+	}
+	for _, group := range groups {
+		if security.Registry.HasMembership(env.Uid(), group) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldsGet extends the standard method so that restricted fields
+// are only described to the users who can access them.
+func commonMixin_FieldsGet(rs m.CommonMixinSet, args models.FieldsGetArgs) map[string]*models.FieldInfo {
+	res := rs.Super().FieldsGet(args)
+	for field := range restrictedFields[rs.ModelName()] {
+		if !canAccessField(rs.Env(), rs.ModelName(), field) {
+			delete(res, field)
+		}
+	}
+	return res
+}
+
+// Read extends the standard method so that restricted fields
+// are only returned to the users who can access them.
+func commonMixin_Read(rs m.CommonMixinSet, fields models.FieldNames) []models.RecordData {
+	res := rs.Super().Read(fields)
+	for field := range restrictedFields[rs.ModelName()] {
+		if canAccessField(rs.Env(), rs.ModelName(), field) {
+			continue
+		}
+		fName := rs.Collection().Model().FieldName(field)
+		for _, rec := range res {
+			rec.Underlying().Unset(fName)
+		}
+	}
+	return res
+}
+
+// Search extends the standard method so that users cannot
+// search on the restricted fields they cannot access.
+func commonMixin_Search(rs m.CommonMixinSet, cond q.CommonMixinCondition) m.CommonMixinSet {
+	for field := range restrictedFields[rs.ModelName()] {
+		if canAccessField(rs.Env(), rs.ModelName(), field) {
+			continue
+		}
+		if f, exists := rs.Collection().Model().Fields().Get(field); exists && cond.HasField(f) {
+			log.Panic(rs.T("Invalid search criterion: %s", field))
+		}
+	}
+	return rs.Super().Search(cond)
+}
+
+func init() {
+	h.CommonMixin().Methods().FieldsGet().Extend(commonMixin_FieldsGet)
+	h.CommonMixin().Methods().Read().Extend(commonMixin_Read)
+	h.CommonMixin().Methods().Search().Extend(commonMixin_Search)
+}
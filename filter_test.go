@@ -4,8 +4,12 @@
 package web
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/pool/h"
@@ -333,3 +337,87 @@ func TestReadGroup(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestLoadViewsFilters(t *testing.T) {
+	Convey("Test loading filters with LoadViews", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demoUser := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			adminUser := h.User().Search(env, q.User().ID().Equals(security.SuperUserID))
+			action := actions.Registry.MustGetByXMLID("base_actions_ir_filters_view")
+			h.Filter().Create(env, h.Filter().NewData().
+				SetName("a").
+				SetUser(demoUser).
+				SetResModel("Filter"))
+			h.Filter().Create(env, h.Filter().NewData().
+				SetName("b").
+				SetUser(demoUser).
+				SetResModel("Filter").
+				SetAction(action.ID).
+				SetIsDefault(true))
+			h.Filter().Create(env, h.Filter().NewData().
+				SetName("c").
+				SetUser(adminUser).
+				SetResModel("Filter"))
+			h.Filter().Create(env, h.Filter().NewData().
+				SetName("d").
+				SetUser(nil).
+				SetResModel("Filter").
+				SetAction(action.ID+1000))
+			loadViewsArgs := webtypes.LoadViewsArgs{
+				Options: webtypes.LoadViewsOptions{LoadFilters: true},
+			}
+			Convey("Filters should not be loaded if not requested", func() {
+				res := h.Filter().NewSet(env).Sudo(demoUser.ID()).LoadViews(webtypes.LoadViewsArgs{})
+				So(res.Filters, ShouldBeNil)
+			})
+			Convey("Loading filters without action", func() {
+				res := h.Filter().NewSet(env).Sudo(demoUser.ID()).LoadViews(loadViewsArgs)
+				So(res.Filters, ShouldHaveLength, 1)
+				So(res.Filters[0]["name"], ShouldEqual, "a")
+				So(res.Filters[0]["user_id"], ShouldResemble, webtypes.RecordIDWithName{
+					ID:   demoUser.ID(),
+					Name: demoUser.NameGet(),
+				})
+				So(res.Filters[0]["domain"], ShouldEqual, "[]")
+				So(res.Filters[0]["context"], ShouldEqual, "{}")
+				So(res.Filters[0]["sort"], ShouldEqual, "[]")
+				So(res.Filters[0]["is_default"], ShouldBeFalse)
+			})
+			Convey("Loading filters with action", func() {
+				loadViewsArgs.Options.ActionID = webtypes.ActionID(action.ID)
+				res := h.Filter().NewSet(env).Sudo(demoUser.ID()).LoadViews(loadViewsArgs)
+				So(res.Filters, ShouldHaveLength, 2)
+				So(res.Filters[0]["name"], ShouldEqual, "a")
+				So(res.Filters[1]["name"], ShouldEqual, "b")
+				So(res.Filters[1]["action_id"], ShouldEqual, action.ID)
+				So(res.Filters[1]["is_default"], ShouldBeTrue)
+			})
+			Convey("Global filters should be loaded with their user set to false", func() {
+				loadViewsArgs.Options.ActionID = webtypes.ActionID(action.ID + 1000)
+				res := h.Filter().NewSet(env).Sudo(demoUser.ID()).LoadViews(loadViewsArgs)
+				So(res.Filters, ShouldHaveLength, 2)
+				So(res.Filters[1]["name"], ShouldEqual, "d")
+				So(res.Filters[1]["user_id"], ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Test unmarshalling action ids of LoadViews options", t, func() {
+		action := actions.Registry.MustGetByXMLID("base_actions_ir_filters_view")
+		for _, data := range []string{
+			fmt.Sprintf(`{"action_id": %d}`, action.ID),
+			fmt.Sprintf(`{"action_id": "%d"}`, action.ID),
+			`{"action_id": "base_actions_ir_filters_view"}`,
+		} {
+			var opts webtypes.LoadViewsOptions
+			So(json.Unmarshal([]byte(data), &opts), ShouldBeNil)
+			So(opts.ActionID, ShouldEqual, action.ID)
+		}
+		for _, data := range []string{`{"action_id": false}`, `{"action_id": null}`, `{}`} {
+			var opts webtypes.LoadViewsOptions
+			So(json.Unmarshal([]byte(data), &opts), ShouldBeNil)
+			So(opts.ActionID, ShouldEqual, 0)
+		}
+		var opts webtypes.LoadViewsOptions
+		So(json.Unmarshal([]byte(`{"action_id": "unknown_action"}`), &opts), ShouldNotBeNil)
+	})
+}
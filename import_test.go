@@ -0,0 +1,168 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestXLSX returns an XLSX file whose first sheet has the given XML sheet data
+func newTestXLSX(sheetData, sharedStrings string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	parts := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/styles.xml":            `<styleSheet><cellXfs count="2"><xf numFmtId="0"/><xf numFmtId="14"/></cellXfs></styleSheet>`,
+		"xl/sharedStrings.xml":     `<sst>` + sharedStrings + `</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>` + sheetData + `</sheetData></worksheet>`,
+	}
+	for name, content := range parts {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	Convey("Testing XLSX file reading", t, func() {
+		content := newTestXLSX(
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="inlineStr"><is><t>Date</t></is></c></row>`+
+				`<row r="2"><c r="A2" t="s"><v>1</v></c><c r="B2"><v>12.5</v></c><c r="C2" s="1"><v>43831</v></c></row>`,
+			`<si><t>Name</t></si><si><r><t>Ali</t></r><r><t>ce</t></r></si>`)
+		rows, err := readXLSX(content)
+		So(err, ShouldBeNil)
+		So(rows, ShouldResemble, [][]string{
+			{"Name", "", "Date"},
+			{"Alice", "12.5", "2020-01-01"},
+		})
+		_, err = readXLSX([]byte("not a zip file"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestImport(t *testing.T) {
+	Convey("Testing file import", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			newWizard := func(fileName, content string) *models.RecordCollection {
+				return h.ImportWizard().Create(env, h.ImportWizard().NewData().
+					SetResModel("res.partner").
+					SetFileName(fileName).
+					SetFile(base64.StdEncoding.EncodeToString([]byte(content)))).Collection()
+			}
+			belgium := h.Country().NewSet(env).GetRecord("base_be")
+			Convey("Previewing a CSV file should match headers with fields", func() {
+				wizard := newWizard("partners.csv", "\xef\xbb\xbfname;Country;Email;unknown\n"+
+					"Alice;Belgium;alice@example.com;x\nBob;France;;y\n")
+				preview := wizard.Call("ParsePreview", webtypes.ImportOptions{Headers: true, Separator: ";"}).(*webtypes.ImportPreview)
+				So(preview.Headers, ShouldResemble, []string{"name", "Country", "Email", "unknown"})
+				So(preview.Preview, ShouldHaveLength, 2)
+				So(preview.Preview[0], ShouldResemble, []string{"Alice", "Belgium", "alice@example.com", "x"})
+				So(preview.Matches[0], ShouldEqual, "name")
+				So(preview.Matches[1], ShouldEqual, "country_id")
+				So(preview.Matches[2], ShouldEqual, "email")
+				So(preview.Matches, ShouldNotContainKey, 3)
+				So(wizard.Call("MatchHeader", "Country/External ID", preview.Fields), ShouldEqual, "country_id/id")
+				So(wizard.Call("MatchHeader", "country_id/.id", preview.Fields), ShouldEqual, "country_id/.id")
+			})
+			Convey("Unsupported files should not be previewed", func() {
+				wizard := newWizard("partners.ods", "data")
+				So(func() { wizard.Call("ParsePreview", webtypes.ImportOptions{}) }, ShouldPanic)
+			})
+			Convey("Importing should create records with many2one values by name or external ID", func() {
+				wizard := newWizard("partners.csv", "name,country_id,parent_id/id,is_company\n"+
+					"Import Corp,Belgium,,yes\n"+
+					"Import Child,,,false\n")
+				res := wizard.Call("Do", webtypes.ImportParams{
+					Fields:  []string{"name", "country_id", "parent_id/id", "is_company"},
+					Options: webtypes.ImportOptions{Headers: true},
+				}).(*webtypes.ImportResult)
+				So(res.Messages, ShouldBeEmpty)
+				So(res.IDs, ShouldHaveLength, 2)
+				corp := h.Partner().Search(env, q.Partner().Name().Equals("Import Corp"))
+				So(corp.Len(), ShouldEqual, 1)
+				So(corp.Country().Equals(belgium), ShouldBeTrue)
+				So(corp.IsCompany(), ShouldBeTrue)
+			})
+			Convey("Rows with an existing external ID should update the record", func() {
+				demo := h.Partner().NewSet(env).GetRecord("base_partner_demo")
+				wizard := newWizard("partners.csv", "id,email,country_id/id\nbase_partner_demo,demo@example.com,base_be\n")
+				res := wizard.Call("Do", webtypes.ImportParams{
+					Fields:  []string{"id", "email", "country_id/id"},
+					Options: webtypes.ImportOptions{Headers: true},
+				}).(*webtypes.ImportResult)
+				So(res.Messages, ShouldBeEmpty)
+				So(res.IDs, ShouldResemble, []int64{demo.ID()})
+				So(demo.Email(), ShouldEqual, "demo@example.com")
+				So(demo.Country().Equals(belgium), ShouldBeTrue)
+			})
+			Convey("Dry runs should report row errors and not import anything", func() {
+				wizard := newWizard("partners.csv", "name,country_id,is_company\n"+
+					"Dry Corp,Belgium,yes\n"+
+					"Dry Child,Nowhere Land,maybe\n")
+				res := wizard.Call("Do", webtypes.ImportParams{
+					Fields:  []string{"name", "country_id", "is_company"},
+					Options: webtypes.ImportOptions{Headers: true},
+					DryRun:  true,
+				}).(*webtypes.ImportResult)
+				So(res.IDs, ShouldBeEmpty)
+				So(res.Messages, ShouldHaveLength, 2)
+				So(res.Messages[0].Row, ShouldEqual, 3)
+				So(res.Messages[0].Field, ShouldEqual, "country_id")
+				So(res.Messages[1].Row, ShouldEqual, 3)
+				So(res.Messages[1].Field, ShouldEqual, "is_company")
+				So(h.Partner().Search(env, q.Partner().Name().Equals("Dry Corp")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Real imports with errors should be rolled back", func() {
+				wizard := newWizard("partners.csv", "Failed Corp,1\nFailed Child,not a number\n")
+				res := wizard.Call("Do", webtypes.ImportParams{
+					Fields: []string{"name", "color"},
+				}).(*webtypes.ImportResult)
+				So(res.IDs, ShouldBeEmpty)
+				So(res.Messages, ShouldHaveLength, 1)
+				So(res.Messages[0].Row, ShouldEqual, 2)
+				So(h.Partner().Search(env, q.Partner().Name().Equals("Failed Corp")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Unknown fields should be reported", func() {
+				wizard := newWizard("partners.csv", "Alice\n")
+				res := wizard.Call("Do", webtypes.ImportParams{Fields: []string{"foo"}}).(*webtypes.ImportResult)
+				So(res.Messages, ShouldHaveLength, 1)
+				So(res.Messages[0].Type, ShouldEqual, "error")
+			})
+			Convey("Read only and computed fields should be refused", func() {
+				wizard := newWizard("partners.csv", "Alice,Somewhere,Corp\n")
+				res := wizard.Call("Do", webtypes.ImportParams{
+					Fields: []string{"name", "contact_address", "commercial_company_name"},
+				}).(*webtypes.ImportResult)
+				So(res.IDs, ShouldBeEmpty)
+				So(res.Messages, ShouldHaveLength, 2)
+				So(res.Messages[0].Field, ShouldEqual, "contact_address")
+				So(res.Messages[1].Field, ShouldEqual, "commercial_company_name")
+			})
+			Convey("Import wizards should only be used by their creator", func() {
+				wizard := newWizard("partners.csv", "Alice\n")
+				demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+				asDemo := h.ImportWizard().NewSet(env).Sudo(demo.ID())
+				So(asDemo.Search(q.ImportWizard().ID().Equals(wizard.Ids()[0])).IsEmpty(), ShouldBeTrue)
+				So(func() {
+					asDemo.Browse(wizard.Ids()).Do(webtypes.ImportParams{Fields: []string{"name"}})
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
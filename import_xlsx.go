@@ -0,0 +1,232 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+)
+
+// xlsxCellText is the text of a shared or inline string,
+// which may be split into rich text runs.
+type xlsxCellText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String returns the full text of this xlsxCellText
+func (t xlsxCellText) String() string {
+	res := t.Text
+	for _, r := range t.Runs {
+		res += r.Text
+	}
+	return res
+}
+
+// xlsxWorkbook is the content of the workbook part of an XLSX file
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships is the content of a relationships part of an XLSX file
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxStyles is the content of the styles part of an XLSX file
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// xlsxSheet is the content of a worksheet part of an XLSX file
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string       `xml:"r,attr"`
+			Type   string       `xml:"t,attr"`
+			Style  int          `xml:"s,attr"`
+			Value  string       `xml:"v"`
+			Inline xlsxCellText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxDateFormat matches the number format codes that display dates or times,
+// once literal strings and colors have been removed.
+var xlsxDateFormat = regexp.MustCompile(`[dmyhs]`)
+
+// xlsxFormatLiterals matches the quoted strings, escaped characters
+// and bracketed sections of a number format code.
+var xlsxFormatLiterals = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+
+// xlsxEpoch is the date of serial number 0 in XLSX files
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// readXLSX returns the rows of the first worksheet of the given XLSX file content.
+//
+// Cells with a date number format are returned formatted with
+// the server date or datetime format.
+func readXLSX(content []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read XLSX file: %s", err)
+	}
+	parts := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	readPart := func(name string, dest interface{}) error {
+		f, ok := parts[name]
+		if !ok {
+			return nil
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return xml.Unmarshal(data, dest)
+	}
+
+	var (
+		workbook      xlsxWorkbook
+		rels          xlsxRelationships
+		styles        xlsxStyles
+		sharedStrings struct {
+			Items []xlsxCellText `xml:"si"`
+		}
+		sheet xlsxSheet
+	)
+	if err := readPart("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	if err := readPart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	if err := readPart("xl/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	if err := readPart("xl/sharedStrings.xml", &sharedStrings); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("XLSX file has no worksheet")
+	}
+	var sheetPart string
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RelID {
+			continue
+		}
+		sheetPart = path.Join("xl", rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			sheetPart = strings.TrimPrefix(rel.Target, "/")
+		}
+	}
+	if _, ok := parts[sheetPart]; !ok {
+		return nil, errors.New("unable to find first worksheet of XLSX file")
+	}
+	if err := readPart(sheetPart, &sheet); err != nil {
+		return nil, err
+	}
+
+	dateFormats := make(map[int]bool)
+	for _, nf := range styles.NumFmts {
+		dateFormats[nf.ID] = xlsxDateFormat.MatchString(strings.ToLower(xlsxFormatLiterals.ReplaceAllString(nf.Code, "")))
+	}
+	var res [][]string
+	for _, row := range sheet.Rows {
+		var values []string
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				col = xlsxColumnIndex(cell.Ref)
+			}
+			for len(values) <= col {
+				values = append(values, "")
+			}
+			switch cell.Type {
+			case "s":
+				idx, err := strconv.Atoi(cell.Value)
+				if err != nil || idx >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("invalid shared string reference in cell %s", cell.Ref)
+				}
+				values[col] = sharedStrings.Items[idx].String()
+			case "inlineStr":
+				values[col] = cell.Inline.String()
+			case "b":
+				values[col] = "False"
+				if cell.Value == "1" {
+					values[col] = "True"
+				}
+			case "", "n":
+				values[col] = cell.Value
+				if cell.Style < len(styles.CellXfs) && cell.Value != "" {
+					numFmt := styles.CellXfs[cell.Style].NumFmtID
+					if (numFmt >= 14 && numFmt <= 22) || (numFmt >= 45 && numFmt <= 47) || dateFormats[numFmt] {
+						values[col] = xlsxFormatDate(cell.Value)
+					}
+				}
+			default:
+				values[col] = cell.Value
+			}
+		}
+		res = append(res, values)
+	}
+	return res, nil
+}
+
+// xlsxColumnIndex returns the index (starting at 0) of the column
+// of the given cell reference (e.g. "AB12").
+func xlsxColumnIndex(ref string) int {
+	var res int
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		res = res*26 + int(r-'A') + 1
+	}
+	return res - 1
+}
+
+// xlsxFormatDate formats the given XLSX date serial number as a date,
+// or as a datetime if it has a time part.
+func xlsxFormatDate(value string) string {
+	serial, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	t := xlsxEpoch.Add(time.Duration(serial * 24 * float64(time.Hour))).Round(time.Second)
+	if serial == float64(int64(serial)) {
+		return t.Format(dates.DefaultServerDateFormat)
+	}
+	return t.Format(dates.DefaultServerDateTimeFormat)
+}
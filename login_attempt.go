@@ -0,0 +1,324 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	"github.com/spf13/viper"
+)
+
+// Default values of the login throttling configuration keys
+const (
+	defaultLoginMaxAttempts        = 5
+	defaultLoginMaxIPAttempts      = 20
+	defaultLoginLockoutDuration    = time.Minute
+	defaultLoginMaxLockoutDuration = time.Hour
+	defaultLoginAttemptsWindow     = time.Hour
+	defaultLoginAttemptsRetention  = 30 * 24 * time.Hour
+)
+
+// memoryLoginMaxKeys is the maximum number of logins and IP addresses
+// whose failed attempts are kept in memory.
+const memoryLoginMaxKeys = 100000
+
+var fields_LoginAttempt = map[string]models.FieldDefinition{
+	"Login":     fields.Char{Required: true, Index: true},
+	"IPAddress": fields.Char{String: "IP Address", JSON: "ip_address", Index: true},
+	"UserAgent": fields.Char{String: "User Agent"},
+	"Cleared": fields.Boolean{Index: true,
+		Help: "Cleared attempts are kept for audit but are not taken into account for throttling"},
+}
+
+// A loginAttemptKey identifies a login or an IP address whose failed login attempts are counted.
+type loginAttemptKey struct {
+	field string
+	value string
+}
+
+// condition returns the condition on LoginAttempt records matching this key
+func (k loginAttemptKey) condition() q.LoginAttemptCondition {
+	if k.field == "ip" {
+		return q.LoginAttempt().IPAddress().Equals(k.value)
+	}
+	return q.LoginAttempt().Login().Equals(k.value)
+}
+
+// A loginFailureCounter counts the failed login attempts per login and IP address.
+type loginFailureCounter interface {
+	// failures returns the number of failed attempts for the given key
+	// since the given time and the time of the last one.
+	failures(rs m.LoginAttemptSet, key loginAttemptKey, since time.Time) (int, time.Time)
+	// add registers a failed attempt for the given key at the given time
+	add(key loginAttemptKey, t time.Time)
+	// clear forgets all failed attempts of the given key
+	clear(key loginAttemptKey)
+}
+
+// A memoryFailureCounter is a loginFailureCounter that keeps the
+// failed attempts in memory. It is only valid for a single instance.
+//
+// At most maxKeys logins and IP addresses are tracked: when a new one fails
+// while the counter is full, the one that failed least recently is forgotten.
+type memoryFailureCounter struct {
+	sync.Mutex
+	entries   map[loginAttemptKey]*list.Element
+	order     *list.List
+	lastSweep time.Time
+	maxKeys   int
+}
+
+// A keyFailures holds the failed attempts of a key in a memoryFailureCounter
+type keyFailures struct {
+	key   loginAttemptKey
+	times []time.Time
+}
+
+// newMemoryFailureCounter returns a new memoryFailureCounter tracking at most maxKeys keys
+func newMemoryFailureCounter(maxKeys int) *memoryFailureCounter {
+	return &memoryFailureCounter{
+		entries: make(map[loginAttemptKey]*list.Element),
+		order:   list.New(),
+		maxKeys: maxKeys,
+	}
+}
+
+// failures returns the number of failed attempts for the given key
+// since the given time and the time of the last one.
+func (mfc *memoryFailureCounter) failures(_ m.LoginAttemptSet, key loginAttemptKey, since time.Time) (int, time.Time) {
+	mfc.Lock()
+	defer mfc.Unlock()
+	if time.Since(mfc.lastSweep) > time.Minute {
+		// Forget the keys that have not failed recently, starting from the least recent
+		for oldest := mfc.order.Back(); oldest != nil; oldest = mfc.order.Back() {
+			kf := oldest.Value.(*keyFailures)
+			if kf.times[len(kf.times)-1].After(since) {
+				break
+			}
+			mfc.remove(oldest)
+		}
+		mfc.lastSweep = time.Now()
+	}
+	elt, ok := mfc.entries[key]
+	if !ok {
+		return 0, time.Time{}
+	}
+	kf := elt.Value.(*keyFailures)
+	var recent []time.Time
+	for _, t := range kf.times {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) == 0 {
+		mfc.remove(elt)
+		return 0, time.Time{}
+	}
+	kf.times = recent
+	return len(recent), recent[len(recent)-1]
+}
+
+// add registers a failed attempt for the given key at the given time
+func (mfc *memoryFailureCounter) add(key loginAttemptKey, t time.Time) {
+	mfc.Lock()
+	defer mfc.Unlock()
+	if elt, ok := mfc.entries[key]; ok {
+		kf := elt.Value.(*keyFailures)
+		kf.times = append(kf.times, t)
+		mfc.order.MoveToFront(elt)
+		return
+	}
+	if mfc.order.Len() >= mfc.maxKeys {
+		mfc.remove(mfc.order.Back())
+	}
+	mfc.entries[key] = mfc.order.PushFront(&keyFailures{key: key, times: []time.Time{t}})
+}
+
+// remove forgets the failed attempts of the given element.
+// The counter must be locked by the caller.
+func (mfc *memoryFailureCounter) remove(elt *list.Element) {
+	mfc.order.Remove(elt)
+	delete(mfc.entries, elt.Value.(*keyFailures).key)
+}
+
+// clear forgets all failed attempts of the given key
+func (mfc *memoryFailureCounter) clear(key loginAttemptKey) {
+	mfc.Lock()
+	defer mfc.Unlock()
+	if elt, ok := mfc.entries[key]; ok {
+		mfc.remove(elt)
+	}
+}
+
+// A databaseFailureCounter is a loginFailureCounter that counts the LoginAttempt
+// records of the database, so that it is shared by all instances of a deployment.
+type databaseFailureCounter struct{}
+
+// failures returns the number of failed attempts for the given key
+// since the given time and the time of the last one.
+func (databaseFailureCounter) failures(rs m.LoginAttemptSet, key loginAttemptKey, since time.Time) (int, time.Time) {
+	attempts := h.LoginAttempt().NewSet(rs.Env()).Sudo().Search(key.condition().
+		And().Cleared().Equals(false).
+		And().CreateDate().Greater(dates.DateTime{Time: since})).
+		OrderBy("CreateDate DESC")
+	count := attempts.SearchCount()
+	if count == 0 {
+		return 0, time.Time{}
+	}
+	return count, attempts.Limit(1).CreateDate().Time
+}
+
+// add is a no-op since failed attempts are always stored in the database
+func (databaseFailureCounter) add(loginAttemptKey, time.Time) {}
+
+// clear is a no-op since cleared attempts are always flagged in the database
+func (databaseFailureCounter) clear(loginAttemptKey) {}
+
+// memoryLoginFailures counts the failed login attempts of this instance
+var memoryLoginFailures = newMemoryFailureCounter(memoryLoginMaxKeys)
+
+// loginFailureCounterFromConfig returns the loginFailureCounter to use according to the
+// Web.LoginAttemptsStore configuration key, which is either 'memory' (the default)
+// or 'database' for deployments with several instances.
+func loginFailureCounterFromConfig() loginFailureCounter {
+	if viper.GetString("Web.LoginAttemptsStore") == "database" {
+		return databaseFailureCounter{}
+	}
+	return memoryLoginFailures
+}
+
+// configDuration returns the duration of the given configuration key, or defaultValue if it is not set
+func configDuration(key string, defaultValue time.Duration) time.Duration {
+	if d := viper.GetDuration(key); d > 0 {
+		return d
+	}
+	return defaultValue
+}
+
+// configInt returns the integer of the given configuration key, or defaultValue if it is not set
+func configInt(key string, defaultValue int) int {
+	if i := viper.GetInt(key); i > 0 {
+		return i
+	}
+	return defaultValue
+}
+
+// loginLockoutDuration returns how long a login or IP address with the given number of
+// failed attempts is locked out, given the maximum number of attempts allowed.
+//
+// The lockout duration doubles at each failed attempt beyond maxAttempts, from
+// the Web.LoginLockoutDuration up to the Web.LoginMaxLockoutDuration configuration keys.
+func loginLockoutDuration(failures, maxAttempts int) time.Duration {
+	if failures < maxAttempts {
+		return 0
+	}
+	base := configDuration("Web.LoginLockoutDuration", defaultLoginLockoutDuration)
+	max := configDuration("Web.LoginMaxLockoutDuration", defaultLoginMaxLockoutDuration)
+	res := base
+	for i := maxAttempts; i < failures && res < max; i++ {
+		res *= 2
+	}
+	if res > max {
+		return max
+	}
+	return res
+}
+
+// CheckLogin returns how long login attempts for the given login from the given
+// IP address are refused because of previous failed attempts. It returns 0 if
+// the attempt is allowed.
+//
+// Failed attempts are counted over the Web.LoginAttemptsWindow configuration key.
+// Logins are locked out after Web.LoginMaxAttempts failed attempts and IP addresses
+// after Web.LoginMaxIPAttempts failed attempts.
+func loginAttempt_CheckLogin(rs m.LoginAttemptSet, login, ipAddress string) time.Duration {
+	counter := loginFailureCounterFromConfig()
+	since := time.Now().Add(-configDuration("Web.LoginAttemptsWindow", defaultLoginAttemptsWindow))
+	var res time.Duration
+	for key, maxAttempts := range map[loginAttemptKey]int{
+		{field: "login", value: login}:  configInt("Web.LoginMaxAttempts", defaultLoginMaxAttempts),
+		{field: "ip", value: ipAddress}: configInt("Web.LoginMaxIPAttempts", defaultLoginMaxIPAttempts),
+	} {
+		failures, last := counter.failures(rs, key, since)
+		if wait := time.Until(last.Add(loginLockoutDuration(failures, maxAttempts))); wait > res {
+			res = wait
+		}
+	}
+	return res
+}
+
+// RegisterFailure records a failed login attempt for the given login from
+// the given IP address and user agent.
+func loginAttempt_RegisterFailure(rs m.LoginAttemptSet, login, ipAddress, userAgent string) m.LoginAttemptSet {
+	res := h.LoginAttempt().NewSet(rs.Env()).Sudo().Create(h.LoginAttempt().NewData().
+		SetLogin(login).
+		SetIPAddress(ipAddress).
+		SetUserAgent(userAgent))
+	counter := loginFailureCounterFromConfig()
+	now := time.Now()
+	counter.add(loginAttemptKey{field: "login", value: login}, now)
+	counter.add(loginAttemptKey{field: "ip", value: ipAddress}, now)
+	return res
+}
+
+// ClearLogin clears the failed login attempts of the given login, for instance
+// after a successful login or when an administrator unlocks an account.
+//
+// Cleared attempts are kept in the database for audit.
+func loginAttempt_ClearLogin(rs m.LoginAttemptSet, login string) {
+	key := loginAttemptKey{field: "login", value: login}
+	h.LoginAttempt().NewSet(rs.Env()).Sudo().Search(key.condition().And().Cleared().Equals(false)).
+		SetCleared(true)
+	loginFailureCounterFromConfig().clear(key)
+}
+
+// RemoveOldAttempts deletes the login attempts older than the Web.LoginAttemptsRetention
+// configuration key, which is never shorter than the Web.LoginAttemptsWindow, and
+// returns the number of deleted attempts.
+func loginAttempt_RemoveOldAttempts(rs m.LoginAttemptSet) int {
+	retention := configDuration("Web.LoginAttemptsRetention", defaultLoginAttemptsRetention)
+	if window := configDuration("Web.LoginAttemptsWindow", defaultLoginAttemptsWindow); retention < window {
+		retention = window
+	}
+	old := h.LoginAttempt().NewSet(rs.Env()).Sudo().Search(q.LoginAttempt().CreateDate().Lower(dates.Now().Add(-retention)))
+	res := old.Len()
+	old.Unlink()
+	return res
+}
+
+// PowerOn removes the old login attempts in addition to the base garbage collections
+func autoVacuum_PowerOn(rs m.AutoVacuumSet) {
+	rs.Super().PowerOn()
+	h.LoginAttempt().NewSet(rs.Env()).RemoveOldAttempts()
+}
+
+// UnlockLogin clears the failed login attempts of the users of this RecordSet
+// so that they can log in again immediately.
+func user_UnlockLogin(rs m.UserSet) bool {
+	for _, user := range rs.Records() {
+		h.LoginAttempt().NewSet(rs.Env()).Sudo().ClearLogin(user.Login())
+	}
+	return true
+}
+
+func init() {
+	models.NewModel("LoginAttempt")
+	h.LoginAttempt().AddFields(fields_LoginAttempt)
+	h.LoginAttempt().NewMethod("CheckLogin", loginAttempt_CheckLogin)
+	h.LoginAttempt().NewMethod("RegisterFailure", loginAttempt_RegisterFailure)
+	h.LoginAttempt().NewMethod("ClearLogin", loginAttempt_ClearLogin)
+	h.LoginAttempt().NewMethod("RemoveOldAttempts", loginAttempt_RemoveOldAttempts)
+
+	h.AutoVacuum().Methods().PowerOn().Extend(autoVacuum_PowerOn)
+
+	h.User().NewMethod("UnlockLogin", user_UnlockLogin)
+}
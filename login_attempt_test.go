@@ -0,0 +1,139 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"container/list"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+// resetMemoryLoginFailures forgets all the failed attempts of the memory store
+func resetMemoryLoginFailures() {
+	memoryLoginFailures.Lock()
+	defer memoryLoginFailures.Unlock()
+	memoryLoginFailures.entries = make(map[loginAttemptKey]*list.Element)
+	memoryLoginFailures.order.Init()
+}
+
+func TestLoginLockoutDuration(t *testing.T) {
+	Convey("Testing login lockout durations", t, func() {
+		So(loginLockoutDuration(0, 5), ShouldEqual, 0)
+		So(loginLockoutDuration(4, 5), ShouldEqual, 0)
+		So(loginLockoutDuration(5, 5), ShouldEqual, time.Minute)
+		So(loginLockoutDuration(6, 5), ShouldEqual, 2*time.Minute)
+		So(loginLockoutDuration(8, 5), ShouldEqual, 8*time.Minute)
+		So(loginLockoutDuration(100, 5), ShouldEqual, time.Hour)
+	})
+}
+
+func TestMemoryFailureCounter(t *testing.T) {
+	Convey("Memory failure counters should forget the oldest keys when full", t, func() {
+		counter := newMemoryFailureCounter(2)
+		now := time.Now()
+		since := now.Add(-time.Hour)
+		counter.add(loginAttemptKey{field: "login", value: "alice"}, now.Add(-2*time.Minute))
+		counter.add(loginAttemptKey{field: "login", value: "bob"}, now.Add(-time.Minute))
+		counter.add(loginAttemptKey{field: "login", value: "alice"}, now)
+		counter.add(loginAttemptKey{field: "login", value: "carol"}, now)
+		So(counter.entries, ShouldHaveLength, 2)
+		So(counter.order.Len(), ShouldEqual, 2)
+		count, _ := counter.failures(nil, loginAttemptKey{field: "login", value: "alice"}, since)
+		So(count, ShouldEqual, 2)
+		count, _ = counter.failures(nil, loginAttemptKey{field: "login", value: "bob"}, since)
+		So(count, ShouldEqual, 0)
+		count, _ = counter.failures(nil, loginAttemptKey{field: "login", value: "carol"}, since)
+		So(count, ShouldEqual, 1)
+		counter.clear(loginAttemptKey{field: "login", value: "carol"})
+		So(counter.entries, ShouldHaveLength, 1)
+		So(counter.order.Len(), ShouldEqual, 1)
+	})
+}
+
+func TestLoginAttempts(t *testing.T) {
+	for _, store := range []string{"memory", "database"} {
+		Convey("Testing login attempts with the "+store+" store", t, func() {
+			resetMemoryLoginFailures()
+			viper.Set("Web.LoginAttemptsStore", store)
+			defer viper.Set("Web.LoginAttemptsStore", "")
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				attempts := h.LoginAttempt().NewSet(env)
+				login := "brute-" + store
+				ip := "192.0.2." + map[string]string{"memory": "1", "database": "2"}[store]
+				for i := 0; i < defaultLoginMaxAttempts-1; i++ {
+					attempts.RegisterFailure(login, ip, "curl")
+				}
+				Convey("Logins should be allowed below the maximum number of attempts", func() {
+					So(attempts.CheckLogin(login, ip), ShouldEqual, 0)
+					So(h.LoginAttempt().Search(env, q.LoginAttempt().Login().Equals(login)).Len(),
+						ShouldEqual, defaultLoginMaxAttempts-1)
+				})
+				Convey("Logins should be locked out after too many failed attempts", func() {
+					attempts.RegisterFailure(login, ip, "curl")
+					wait := attempts.CheckLogin(login, ip)
+					So(wait, ShouldBeGreaterThan, 0)
+					So(wait, ShouldBeLessThanOrEqualTo, time.Minute)
+					So(attempts.CheckLogin(login, "198.51.100.1"), ShouldBeGreaterThan, 0)
+					Convey("Other logins from the same IP address should be allowed", func() {
+						So(attempts.CheckLogin("other-"+store, ip), ShouldEqual, 0)
+					})
+					Convey("Clearing the login should unlock it and keep the audit trail", func() {
+						attempts.ClearLogin(login)
+						So(attempts.CheckLogin(login, ip), ShouldEqual, 0)
+						cleared := h.LoginAttempt().Search(env, q.LoginAttempt().Login().Equals(login))
+						So(cleared.Len(), ShouldEqual, defaultLoginMaxAttempts)
+						for _, attempt := range cleared.Records() {
+							So(attempt.Cleared(), ShouldBeTrue)
+						}
+					})
+				})
+				Convey("IP addresses should be locked out after too many failed attempts", func() {
+					for i := 0; i < defaultLoginMaxIPAttempts; i++ {
+						attempts.RegisterFailure("spray-"+store, ip, "curl")
+						attempts.ClearLogin("spray-" + store)
+					}
+					So(attempts.CheckLogin("another-"+store, ip), ShouldBeGreaterThan, 0)
+				})
+			}), ShouldBeNil)
+		})
+	}
+}
+
+func TestRemoveOldAttempts(t *testing.T) {
+	Convey("Testing the removal of old login attempts", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			attempts := h.LoginAttempt().NewSet(env)
+			old := attempts.RegisterFailure("old-login", "192.0.2.20", "curl")
+			recent := attempts.RegisterFailure("recent-login", "192.0.2.20", "curl")
+			env.Cr().Execute(`UPDATE login_attempt SET create_date = $1 WHERE id = $2`,
+				time.Now().Add(-defaultLoginAttemptsRetention-time.Hour), old.ID())
+			So(attempts.RemoveOldAttempts(), ShouldBeGreaterThanOrEqualTo, 1)
+			So(h.LoginAttempt().Search(env, q.LoginAttempt().ID().Equals(old.ID())).IsEmpty(), ShouldBeTrue)
+			So(h.LoginAttempt().Search(env, q.LoginAttempt().ID().Equals(recent.ID())).IsEmpty(), ShouldBeFalse)
+		}), ShouldBeNil)
+	})
+}
+
+func TestUnlockLogin(t *testing.T) {
+	Convey("Testing user unlocking", t, func() {
+		resetMemoryLoginFailures()
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demoUser := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			attempts := h.LoginAttempt().NewSet(env)
+			for i := 0; i < defaultLoginMaxAttempts; i++ {
+				attempts.RegisterFailure(demoUser.Login(), "192.0.2.10", "curl")
+			}
+			So(attempts.CheckLogin(demoUser.Login(), "192.0.2.10"), ShouldBeGreaterThan, 0)
+			So(demoUser.UnlockLogin(), ShouldBeTrue)
+			So(attempts.CheckLogin(demoUser.Login(), "192.0.2.10"), ShouldEqual, 0)
+		}), ShouldBeNil)
+	})
+}
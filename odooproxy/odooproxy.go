@@ -20,6 +20,12 @@ func ConvertModelName(val string) string {
 		res = "Company"
 	case "ir.filters":
 		res = "Filter"
+	case "ir.exports":
+		res = "ExportTemplate"
+	case "ir.exports.line":
+		res = "ExportTemplateLine"
+	case "base_import.import":
+		res = "ImportWizard"
 	case "ir.attachment":
 		res = "Attachment"
 	case "ir.translation":
@@ -0,0 +1,264 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// Default values of the password policy configuration keys
+const (
+	defaultPasswordMinLength   = 8
+	defaultPasswordMinClasses  = 1
+	defaultPasswordHistory     = 3
+	defaultPasswordMinStrength = 2
+)
+
+// commonPasswords are frequently used passwords and words that
+// make a password guessable wherever they appear in it.
+var commonPasswords = []string{
+	"password", "passw0rd", "123456", "12345678", "123456789", "1234567890", "qwerty", "qwertz",
+	"azerty", "abc123", "111111", "000000", "123123", "654321", "letmein", "welcome", "admin",
+	"administrator", "login", "master", "monkey", "dragon", "football", "baseball", "soccer",
+	"iloveyou", "sunshine", "princess", "shadow", "superman", "batman", "starwars", "trustno1",
+	"hello", "freedom", "whatever", "secret", "changeme", "default", "access", "charlie", "michael",
+	"jennifer", "jordan", "hunter", "ranger", "buster", "summer", "winter", "spring", "autumn",
+	"flower", "computer", "internet", "service", "test", "guest", "user", "root", "hexya", "odoo",
+}
+
+// keyboardRows are the rows of common keyboard layouts, whose
+// adjacent keys make guessable sequences.
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"azertyuiop", "qsdfghjklm", "wxcvbn", "qwertzuiop", "yxcvbnm",
+}
+
+// leetReplacer undoes the usual character substitutions before dictionary matching
+var leetReplacer = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// passwordHashRegexp matches password hashes, as opposed to plain text
+// passwords that may still be stored before the first initialization.
+var passwordHashRegexp = regexp.MustCompile(`^\$[^$]+\$[^$]+\$.`)
+
+// passwordClasses returns the number of character classes (lower case,
+// upper case, digits and symbols) used in the given password.
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// adjacentKeys returns true if a and b are next to each other on a keyboard row
+func adjacentKeys(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		j := strings.IndexRune(row, b)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordStrength returns a score between 0 (too guessable) and 4 (very
+// unguessable) of the given password, in the manner of zxcvbn.
+//
+// The score is computed from an estimation of the number of guesses needed to
+// find the password, where common passwords, the given user inputs (such as the
+// login or the name of the user), repeated characters, alphabetical sequences and
+// keyboard sequences are much cheaper to guess than random characters.
+func passwordStrength(password string, userInputs ...string) int {
+	runes := []rune(strings.ToLower(password))
+	if len(runes) == 0 {
+		return 0
+	}
+	// Characters covered by dictionary words are only counted once per word
+	covered := make([]bool, len(runes))
+	var bits float64
+	unleet := []rune(leetReplacer.Replace(string(runes)))
+	if len(unleet) != len(runes) {
+		unleet = runes
+	}
+	words := append([]string{}, commonPasswords...)
+	for _, input := range userInputs {
+		for _, word := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(word) >= 3 {
+				words = append(words, word)
+			}
+		}
+	}
+	for _, word := range words {
+		for _, candidate := range []string{string(runes), string(unleet)} {
+			idx := strings.Index(candidate, word)
+			if idx < 0 {
+				continue
+			}
+			start := len([]rune(candidate[:idx]))
+			var alreadyCovered bool
+			for i := start; i < start+len([]rune(word)); i++ {
+				alreadyCovered = alreadyCovered || covered[i]
+				covered[i] = true
+			}
+			if !alreadyCovered {
+				bits += math.Log2(float64(len(words))) + 1
+			}
+			break
+		}
+	}
+	charsetBits := math.Log2(float64(map[int]int{1: 26, 2: 36, 3: 62, 4: 95}[passwordClasses(password)]))
+	for i, r := range runes {
+		switch {
+		case covered[i]:
+		case i > 0 && r == runes[i-1]:
+			bits++
+		case i > 0 && (r-runes[i-1] == 1 || runes[i-1]-r == 1):
+			bits++
+		case i > 0 && adjacentKeys(r, runes[i-1]):
+			bits += 1.5
+		default:
+			bits += charsetBits
+		}
+	}
+	// Thresholds of zxcvbn: 10^3, 10^6, 10^8 and 10^10 guesses
+	for score, threshold := range []float64{3, 6, 8, 10} {
+		if bits < threshold*math.Log2(10) {
+			return score
+		}
+	}
+	return 4
+}
+
+// passwordHistory returns the hashes of the previous passwords of
+// this user that may not be reused, most recent first.
+func passwordHistory(rs m.UserSet) []string {
+	size := configInt("Web.PasswordHistory", defaultPasswordHistory)
+	if size <= 0 {
+		return nil
+	}
+	var current string
+	rs.Env().Cr().Get(&current, `SELECT COALESCE(password, '') FROM "user" WHERE id=?`, rs.ID())
+	res := []string{current}
+	for _, hash := range strings.Split(rs.Sudo().PasswordHistory(), "\n") {
+		if hash != "" {
+			res = append(res, hash)
+		}
+	}
+	if len(res) > size {
+		res = res[:size]
+	}
+	return res
+}
+
+// CheckPasswordPolicy panics if the given password cannot be set as the new
+// password of this user according to the password policy.
+//
+// The policy is set by the following configuration keys:
+//   - Web.PasswordMinLength: the minimum number of characters (default 8)
+//   - Web.PasswordMinClasses: the minimum number of character classes among
+//     lower case, upper case, digits and symbols (default 1)
+//   - Web.PasswordHistory: the number of previous passwords, including the
+//     current one, that cannot be reused (default 3)
+//   - Web.PasswordMinStrength: the minimum strength score between 0 and 4 as
+//     estimated by passwordStrength (default 2)
+//
+// In addition, passwords may never contain the login of the user.
+//
+// This method is only meant to be called as superuser, since it tells
+// whether the given password is one of the previous passwords of the user.
+func user_CheckPasswordPolicy(rs m.UserSet, password string) {
+	rs.EnsureOne()
+	user := rs.Sudo()
+	if minLength := configInt("Web.PasswordMinLength", defaultPasswordMinLength); len([]rune(password)) < minLength {
+		log.Panic(rs.T("The password must contain at least %d characters.", minLength))
+	}
+	if minClasses := configInt("Web.PasswordMinClasses", defaultPasswordMinClasses); passwordClasses(password) < minClasses {
+		log.Panic(rs.T("The password must contain at least %d of lower case letters, upper case letters, digits and symbols.", minClasses))
+	}
+	if login := strings.ToLower(user.Login()); len(login) >= 3 && strings.Contains(strings.ToLower(password), login) {
+		log.Panic(rs.T("The password must not contain the login."))
+	}
+	if minStrength := configInt("Web.PasswordMinStrength", defaultPasswordMinStrength); passwordStrength(password, user.Login(), user.Name(), user.Email()) < minStrength {
+		log.Panic(rs.T("The password is too easy to guess. Avoid common words, names and sequences such as 'abcd' or 'qwerty'."))
+	}
+	for _, hash := range passwordHistory(user) {
+		if hash != "" && user.VerifyPassword(password, hash) {
+			log.Panic(rs.T("The password must differ from the previous ones."))
+		}
+	}
+}
+
+// InversePassword extends the standard method to keep the hashes of the
+// previous passwords, so that they cannot be reused.
+func user_InversePassword(rs m.UserSet, value string) {
+	var previous string
+	rs.Env().Cr().Get(&previous, `SELECT COALESCE(password, '') FROM "user" WHERE id=?`, rs.ID())
+	rs.Super().InversePassword(value)
+	if !passwordHashRegexp.MatchString(previous) {
+		// There was no password or it has just been hashed for the first time
+		return
+	}
+	// The current password is not stored in the history
+	size := configInt("Web.PasswordHistory", defaultPasswordHistory) - 1
+	history := []string{previous}
+	for _, hash := range strings.Split(rs.Sudo().PasswordHistory(), "\n") {
+		if hash != "" {
+			history = append(history, hash)
+		}
+	}
+	if len(history) > size {
+		history = history[:size]
+	}
+	rs.Sudo().SetPasswordHistory(strings.Join(history, "\n"))
+}
+
+// ChangePassword extends the standard method to enforce the password policy
+func user_ChangePassword(rs m.UserSet, oldPassword, newPassword string) bool {
+	h.User().NewSet(rs.Env()).CurrentUser().Sudo().CheckPasswordPolicy(newPassword)
+	return rs.Super().ChangePassword(oldPassword, newPassword)
+}
+
+// InverseNewPassword extends the standard method to enforce the password
+// policy when administrators set the password of a user.
+func user_InverseNewPassword(rs m.UserSet, value string) {
+	if value != "" {
+		rs.Sudo().CheckPasswordPolicy(value)
+	}
+	rs.Super().InverseNewPassword(value)
+}
+
+// ChangePasswordButton extends the standard method to enforce the password
+// policy when administrators change passwords with the wizard.
+func userChangePasswordWizard_ChangePasswordButton(rs m.UserChangePasswordWizardSet) {
+	for _, userLine := range rs.Users().Records() {
+		userLine.User().Sudo().CheckPasswordPolicy(userLine.NewPassword())
+	}
+	rs.Super().ChangePasswordButton()
+}
+
+func init() {
+	h.User().NewMethod("CheckPasswordPolicy", user_CheckPasswordPolicy)
+	h.User().Methods().InversePassword().Extend(user_InversePassword)
+	h.User().Methods().ChangePassword().Extend(user_ChangePassword)
+	h.User().Methods().InverseNewPassword().Extend(user_InverseNewPassword)
+	h.UserChangePasswordWizard().Methods().ChangePasswordButton().Extend(userChangePasswordWizard_ChangePasswordButton)
+}
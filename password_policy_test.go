@@ -0,0 +1,141 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stubPasswordResetSender keeps the last link it was asked to send
+type stubPasswordResetSender struct {
+	login string
+	link  string
+}
+
+// SendPasswordReset stores the given link
+func (s *stubPasswordResetSender) SendPasswordReset(user m.UserSet, link string) error {
+	s.login = user.Login()
+	s.link = link
+	return nil
+}
+
+func TestPasswordStrength(t *testing.T) {
+	Convey("Testing password strength estimation", t, func() {
+		So(passwordStrength(""), ShouldEqual, 0)
+		So(passwordStrength("password"), ShouldEqual, 0)
+		So(passwordStrength("P@ssw0rd"), ShouldEqual, 0)
+		So(passwordStrength("password123"), ShouldBeLessThan, 2)
+		So(passwordStrength("qwertyuiop"), ShouldBeLessThan, 2)
+		So(passwordStrength("aaaaaaaaaaaa"), ShouldBeLessThan, 2)
+		So(passwordStrength("jsmith2020", "jsmith@example.com"), ShouldBeLessThan, passwordStrength("jsmith2020"))
+		So(passwordStrength("correct horse battery staple"), ShouldEqual, 4)
+		So(passwordStrength("Tr0ub4dor&3x!"), ShouldEqual, 4)
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	Convey("Testing the password policy", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			Convey("Weak passwords should be refused", func() {
+				So(func() { demo.CheckPasswordPolicy("Xy7!q") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("password123") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("My-demo-Password-7") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("Tr0ub4dor&3x!") }, ShouldNotPanic)
+			})
+			Convey("Previous passwords should not be reused", func() {
+				demo.SetPassword("Blue-Giraffe-42")
+				demo.SetPassword("Quiet-Lantern-17")
+				demo.SetPassword("Frozen-Cactus-93")
+				So(strings.Split(demo.PasswordHistory(), "\n"), ShouldHaveLength, 2)
+				So(func() { demo.CheckPasswordPolicy("Frozen-Cactus-93") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("Quiet-Lantern-17") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("Blue-Giraffe-42") }, ShouldPanic)
+				So(func() { demo.CheckPasswordPolicy("demo") }, ShouldPanic)
+				demo.SetPassword("Silver-Meadow-58")
+				So(func() { demo.CheckPasswordPolicy("Blue-Giraffe-42") }, ShouldNotPanic)
+			})
+			Convey("The password history should not be readable", func() {
+				demo.SetPassword("Blue-Giraffe-42")
+				res := demo.Sudo(demo.ID()).Read(models.FieldNames{h.User().Fields().Name(), h.User().Fields().PasswordHistory()})
+				So(res[0].Underlying().Has(h.User().Fields().PasswordHistory()), ShouldBeFalse)
+				So(func() { demo.Sudo(demo.ID()).Search(q.User().PasswordHistory().IsNotNull()) }, ShouldPanic)
+			})
+			Convey("Changing one's password should enforce the policy", func() {
+				demoUser := demo.Sudo(demo.ID())
+				So(func() { demoUser.ChangePassword("demo", "password123") }, ShouldPanic)
+				So(demoUser.ChangePassword("demo", "Tr0ub4dor&3x!"), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	Convey("Testing the password reset flow", t, func() {
+		sender := new(stubPasswordResetSender)
+		RegisterPasswordResetSender(sender)
+		defer RegisterPasswordResetSender(nil)
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			users := h.User().NewSet(env)
+			Convey("Unknown logins should not get a link", func() {
+				users.RequestPasswordReset("nobody@example.com", "192.0.2.50")
+				So(sender.link, ShouldBeBlank)
+			})
+			Convey("Reset links should allow to set a new password once", func() {
+				users.RequestPasswordReset(demo.Login(), "192.0.2.50")
+				So(sender.login, ShouldEqual, demo.Login())
+				link, err := url.Parse(sender.link)
+				So(err, ShouldBeNil)
+				So(link.Path, ShouldEqual, "/web/reset_password")
+				token := link.Query().Get("token")
+				So(passwordResetUser(users, token).ID(), ShouldEqual, demo.ID())
+				So(func() { users.ResetPassword(token+"0", "Tr0ub4dor&3x!") }, ShouldPanic)
+				So(func() { users.ResetPassword(token, "password123") }, ShouldPanic)
+				users.ResetPassword(token, "Tr0ub4dor&3x!")
+				var pwdHash string
+				env.Cr().Get(&pwdHash, `SELECT password FROM "user" WHERE id=?`, demo.ID())
+				So(demo.VerifyPassword("Tr0ub4dor&3x!", pwdHash), ShouldBeTrue)
+				So(func() { users.ResetPassword(token, "Quiet-Lantern-17") }, ShouldPanic)
+			})
+			Convey("Reset requests should be throttled by IP address without locking the login", func() {
+				defer passwordResetRequests.clear(loginAttemptKey{field: "ip", value: "192.0.2.51"})
+				for i := 0; i < defaultPasswordResetMaxRequests; i++ {
+					users.RequestPasswordReset(demo.Login(), "192.0.2.51")
+				}
+				sender.link = ""
+				users.RequestPasswordReset(demo.Login(), "192.0.2.51")
+				So(sender.link, ShouldBeBlank)
+				users.RequestPasswordReset(demo.Login(), "192.0.2.53")
+				So(sender.link, ShouldNotBeBlank)
+				So(h.LoginAttempt().NewSet(env).CheckLogin(demo.Login(), "192.0.2.54"), ShouldEqual, 0)
+			})
+			Convey("Reset requests should be ignored without sender", func() {
+				RegisterPasswordResetSender(nil)
+				users.RequestPasswordReset(demo.Login(), "192.0.2.52")
+				So(sender.link, ShouldBeBlank)
+			})
+			Convey("Expired tokens should be refused", func() {
+				expiry := time.Now().Add(-time.Minute).Unix()
+				token := fmt.Sprintf("%d.%d.%s", demo.ID(), expiry, passwordResetSignature(demo, expiry))
+				So(passwordResetUser(users, token).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Reset methods should not be callable by users", func() {
+				So(func() { users.Sudo(demo.ID()).RequestPasswordReset(demo.Login(), "192.0.2.50") }, ShouldPanic)
+				So(func() { users.Sudo(demo.ID()).CheckPasswordPolicy("Tr0ub4dor&3x!") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// defaultPasswordResetTokenLifetime is the default validity
+// of the links sent to users who forgot their password.
+const defaultPasswordResetTokenLifetime = time.Hour
+
+// defaultPasswordResetMaxRequests is the default number of password
+// reset requests allowed from an IP address over the attempts window.
+const defaultPasswordResetMaxRequests = 5
+
+// passwordResetRequests counts the password reset requests by IP address
+var passwordResetRequests = newMemoryFailureCounter(memoryLoginMaxKeys)
+
+// passwordResetSecretParam is the configuration parameter holding
+// the secret with which password reset tokens are signed.
+const passwordResetSecretParam = "web.password_reset.secret"
+
+// A PasswordResetSender delivers password reset links to users
+type PasswordResetSender interface {
+	// SendPasswordReset sends the given link to the given user. Following
+	// the link allows to choose a new password without knowing the current one.
+	SendPasswordReset(user m.UserSet, link string) error
+}
+
+// passwordResetSender is the PasswordResetSender used to deliver reset links.
+// Password reset is disabled until a sender is registered.
+var passwordResetSender PasswordResetSender
+
+// RegisterPasswordResetSender sets the PasswordResetSender with which
+// password reset links are delivered to users, for instance by email.
+//
+// Registering a nil sender disables password reset.
+func RegisterPasswordResetSender(sender PasswordResetSender) {
+	passwordResetSender = sender
+}
+
+// passwordResetSecret returns the secret with which password
+// reset tokens are signed, creating it on first use.
+func passwordResetSecret(rs m.UserSet) []byte {
+	params := h.ConfigParameter().NewSet(rs.Env()).Sudo()
+	secret := params.GetParam(passwordResetSecretParam, "")
+	if secret == "" {
+		secret = newAPIKey()
+		params.SetParam(passwordResetSecretParam, secret)
+	}
+	return []byte(secret)
+}
+
+// passwordResetSignature returns the signature of a password reset token of
+// the given user expiring at the given unix time.
+//
+// The signature covers the current password hash of the user, so that the
+// token cannot be used anymore once the password has been changed.
+func passwordResetSignature(user m.UserSet, expiry int64) string {
+	var pwdHash string
+	user.Env().Cr().Get(&pwdHash, `SELECT COALESCE(password, '') FROM "user" WHERE id=?`, user.ID())
+	mac := hmac.New(sha256.New, passwordResetSecret(user))
+	fmt.Fprintf(mac, "%d:%d:%s", user.ID(), expiry, pwdHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// passwordResetToken returns a new password reset token for the given user
+func passwordResetToken(user m.UserSet) string {
+	expiry := time.Now().Add(configDuration("Web.PasswordResetTokenLifetime", defaultPasswordResetTokenLifetime)).Unix()
+	return fmt.Sprintf("%d.%d.%s", user.ID(), expiry, passwordResetSignature(user, expiry))
+}
+
+// passwordResetUser returns the active user of the given password reset
+// token, or an empty set if the token is invalid or has expired.
+func passwordResetUser(rs m.UserSet, token string) m.UserSet {
+	res := h.User().NewSet(rs.Env())
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return res
+	}
+	uid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return res
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return res
+	}
+	user := h.User().Search(rs.Env(), q.User().ID().Equals(uid)).Sudo()
+	if user.IsEmpty() || !hmac.Equal([]byte(parts[2]), []byte(passwordResetSignature(user, expiry))) {
+		return res
+	}
+	return user
+}
+
+// RequestPasswordReset sends a link to choose a new password to the
+// active user with the given login, through the registered PasswordResetSender.
+//
+// Requests are throttled by IP address: requests from an address that has made
+// more than Web.PasswordResetMaxRequests requests over the Web.LoginAttemptsWindow
+// configuration key are ignored. They are not counted as failed logins, so that
+// they cannot be used to lock users out.
+//
+// Nothing happens if there is no such user, so that callers cannot
+// find out which logins exist.
+func user_RequestPasswordReset(rs m.UserSet, login, ipAddress string) {
+	if passwordResetSender == nil {
+		log.Warn("password reset requested but no password reset sender is registered", "login", login)
+		return
+	}
+	key := loginAttemptKey{field: "ip", value: ipAddress}
+	since := time.Now().Add(-configDuration("Web.LoginAttemptsWindow", defaultLoginAttemptsWindow))
+	maxRequests := configInt("Web.PasswordResetMaxRequests", defaultPasswordResetMaxRequests)
+	if count, _ := passwordResetRequests.failures(nil, key, since); count >= maxRequests {
+		log.Info("password reset request throttled", "login", login, "ip", ipAddress)
+		return
+	}
+	passwordResetRequests.add(key, time.Now())
+	user := h.User().Search(rs.Env(), q.User().Login().Equals(login)).Sudo()
+	if user.Len() != 1 {
+		log.Info("password reset requested for unknown login", "login", login)
+		return
+	}
+	baseURL := h.ConfigParameter().NewSet(rs.Env()).Sudo().GetParam("web.base.url", "")
+	link := fmt.Sprintf("%s/web/reset_password?token=%s", strings.TrimSuffix(baseURL, "/"),
+		url.QueryEscape(passwordResetToken(user)))
+	if err := passwordResetSender.SendPasswordReset(user, link); err != nil {
+		log.Warn("unable to send password reset link", "login", login, "error", err)
+	}
+}
+
+// ResetPassword sets the given password as the new password of the user of
+// the given password reset token, if the token is valid and the password
+// complies with the password policy.
+//
+// All the sessions of the user are closed and its failed login
+// attempts are cleared.
+func user_ResetPassword(rs m.UserSet, token, newPassword string) {
+	user := passwordResetUser(rs, token)
+	if user.IsEmpty() {
+		log.Panic(rs.T("This password reset link is invalid or has expired."))
+	}
+	user.CheckPasswordPolicy(newPassword)
+	user.SetPassword(newPassword)
+	h.UserSession().Search(rs.Env(), q.UserSession().UserFilteredOn(q.User().ID().Equals(user.ID()))).Sudo().Unlink()
+	h.LoginAttempt().NewSet(rs.Env()).Sudo().ClearLogin(user.Login())
+}
+
+func init() {
+	h.User().NewMethod("RequestPasswordReset", user_RequestPasswordReset)
+	h.User().NewMethod("ResetPassword", user_ResetPassword)
+}
@@ -0,0 +1,533 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package qrcode is a minimal QR Code encoder.
+//
+// It encodes binary data in byte mode with the medium error correction
+// level, which is enough for short payloads such as URIs (up to 213 bytes).
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// quietZone is the width in modules of the blank border around a QR Code
+const quietZone = 4
+
+// ErrTooLong is returned by Encode when the data does not fit in a supported QR Code version
+var ErrTooLong = errors.New("data too long to be encoded in a QR Code")
+
+// A versionInfo holds the block structure of a QR Code version for the medium
+// error correction level and the positions of its alignment patterns.
+type versionInfo struct {
+	ecPerBlock int
+	// blocks is the number of data codewords of each block
+	blocks    []int
+	alignment []int
+}
+
+// dataCodewords returns the number of data codewords of this version
+func (vi versionInfo) dataCodewords() int {
+	var res int
+	for _, b := range vi.blocks {
+		res += b
+	}
+	return res
+}
+
+// versions holds the supported QR Code versions, starting with version 1.
+var versions = []versionInfo{
+	{ecPerBlock: 10, blocks: []int{16}},
+	{ecPerBlock: 16, blocks: []int{28}, alignment: []int{6, 18}},
+	{ecPerBlock: 26, blocks: []int{44}, alignment: []int{6, 22}},
+	{ecPerBlock: 18, blocks: []int{32, 32}, alignment: []int{6, 26}},
+	{ecPerBlock: 24, blocks: []int{43, 43}, alignment: []int{6, 30}},
+	{ecPerBlock: 16, blocks: []int{27, 27, 27, 27}, alignment: []int{6, 34}},
+	{ecPerBlock: 18, blocks: []int{31, 31, 31, 31}, alignment: []int{6, 22, 38}},
+	{ecPerBlock: 22, blocks: []int{38, 38, 39, 39}, alignment: []int{6, 24, 42}},
+	{ecPerBlock: 22, blocks: []int{36, 36, 36, 37, 37}, alignment: []int{6, 26, 46}},
+	{ecPerBlock: 26, blocks: []int{43, 43, 43, 43, 44}, alignment: []int{6, 28, 50}},
+}
+
+// A Code is an encoded QR Code
+type Code struct {
+	// Size is the number of modules of each side of the symbol
+	Size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// Black returns true if the module at the given column
+// and row is black. Modules outside the symbol are white.
+func (c *Code) Black(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y][x]
+}
+
+// Image returns the QR Code as an image with the given number of
+// pixels per module, including the quiet zone around the symbol.
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.Size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			col := color.Gray{Y: 255}
+			if c.Black(x/scale-quietZone, y/scale-quietZone) {
+				col = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, col)
+		}
+	}
+	return img
+}
+
+// PNG returns the QR Code as a PNG image with the given number of pixels per module.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode returns the smallest QR Code that encodes the given data.
+func Encode(data []byte) (*Code, error) {
+	for i, vi := range versions {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*vi.dataCodewords() {
+			continue
+		}
+		codewords := encodeData(data, countBits, vi.dataCodewords())
+		c := newCode(version)
+		c.drawCodewords(addErrorCorrection(codewords, vi))
+		c.applyBestMask()
+		return c, nil
+	}
+	return nil, ErrTooLong
+}
+
+// encodeData returns the data codewords of the given data in byte mode,
+// padded to the given number of codewords.
+func encodeData(data []byte, countBits, capacity int) []byte {
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits)
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+	bb.append(0, minInt(4, 8*capacity-bb.len))
+	bb.append(0, (8-bb.len%8)%8)
+	res := bb.bytes()
+	for pad := byte(0xEC); len(res) < capacity; pad ^= 0xEC ^ 0x11 {
+		res = append(res, pad)
+	}
+	return res
+}
+
+// addErrorCorrection splits the data codewords into the blocks of the given
+// version, computes their error correction codewords and returns all
+// codewords interleaved in their final order.
+func addErrorCorrection(data []byte, vi versionInfo) []byte {
+	divisor := reedSolomonDivisor(vi.ecPerBlock)
+	var dataBlocks, ecBlocks [][]byte
+	for _, size := range vi.blocks {
+		dataBlocks = append(dataBlocks, data[:size])
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(data[:size], divisor))
+		data = data[size:]
+	}
+	var res []byte
+	for i := 0; i < vi.blocks[len(vi.blocks)-1]; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				res = append(res, block[i])
+			}
+		}
+	}
+	for i := 0; i < vi.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			res = append(res, block[i])
+		}
+	}
+	return res
+}
+
+// newCode returns a Code of the given version with
+// all function patterns drawn and reserved.
+func newCode(version int) *Code {
+	size := 17 + 4*version
+	c := &Code{
+		Size:     size,
+		modules:  make([][]bool, size),
+		function: make([][]bool, size),
+	}
+	for i := range c.modules {
+		c.modules[i] = make([]bool, size)
+		c.function[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+	align := versions[version-1].alignment
+	for i, x := range align {
+		for j, y := range align {
+			if (i == 0 && j == 0) || (i == 0 && j == len(align)-1) || (i == len(align)-1 && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+	// Reserve the format modules until the mask is chosen
+	c.drawFormat(0)
+	if version >= 7 {
+		c.drawVersion(version)
+	}
+	return c
+}
+
+// setFunction sets the module at the given column and row as part of a function pattern
+func (c *Code) setFunction(x, y int, black bool) {
+	c.modules[y][x] = black
+	c.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centered on the given module
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := maxInt(absInt(dx), absInt(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centered on the given module
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// formatBits returns the 15 bits format information for
+// the medium error correction level and the given mask.
+func formatBits(mask int) int {
+	// The medium error correction level is encoded as 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawFormat draws both copies of the format information for the given mask
+func (c *Code) drawFormat(mask int) {
+	bits := formatBits(mask)
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, getBit(bits, i))
+	}
+	c.setFunction(8, 7, getBit(bits, 6))
+	c.setFunction(8, 8, getBit(bits, 7))
+	c.setFunction(7, 8, getBit(bits, 8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, getBit(bits, i))
+	}
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, getBit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, getBit(bits, i))
+	}
+	// Dark module
+	c.setFunction(8, c.Size-8, true)
+}
+
+// versionBits returns the 18 bits version information of the given version
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// drawVersion draws both copies of the version information
+func (c *Code) drawVersion(version int) {
+	bits := versionBits(version)
+	for i := 0; i < 18; i++ {
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, getBit(bits, i))
+		c.setFunction(b, a, getBit(bits, i))
+	}
+}
+
+// drawCodewords places the given codewords in the zigzag order
+// in all the modules that are not part of a function pattern.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = getBit(int(codewords[i>>3]), 7-i&7)
+				i++
+			}
+		}
+	}
+}
+
+// masked returns true if the module at the given column and row is inverted by the given mask
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask inverts the data modules with the given mask.
+// Applying the same mask twice restores the original modules.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y][x] && masked(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// applyBestMask applies the mask with the lowest penalty score and draws its format information
+func (c *Code) applyBestMask() {
+	best, bestScore := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if score := c.penalty(); bestScore < 0 || score < bestScore {
+			best, bestScore = mask, score
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormat(best)
+}
+
+// penalty returns the penalty score of the symbol as defined by the QR Code specification.
+// Masks with lower scores are easier to read.
+func (c *Code) penalty() int {
+	var res, black int
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for i := 0; i < c.Size; i++ {
+		runRow, runCol := 1, 1
+		for j := 0; j < c.Size; j++ {
+			if c.modules[i][j] {
+				black++
+			}
+			if j == 0 {
+				continue
+			}
+			// Runs of five or more modules of the same color
+			if c.modules[i][j] == c.modules[i][j-1] {
+				runRow++
+			} else {
+				runRow = 1
+			}
+			if runRow == 5 {
+				res += 3
+			} else if runRow > 5 {
+				res++
+			}
+			if c.modules[j][i] == c.modules[j-1][i] {
+				runCol++
+			} else {
+				runCol = 1
+			}
+			if runCol == 5 {
+				res += 3
+			} else if runCol > 5 {
+				res++
+			}
+			// 2x2 blocks of the same color
+			if i > 0 && c.modules[i][j] == c.modules[i][j-1] &&
+				c.modules[i][j] == c.modules[i-1][j] && c.modules[i][j] == c.modules[i-1][j-1] {
+				res += 3
+			}
+		}
+		// Patterns looking like finder patterns with four light modules on one side
+		for j := 0; j+len(finderLike) <= c.Size; j++ {
+			rowMatch, colMatch := true, true
+			for k, b := range finderLike {
+				rowMatch = rowMatch && c.modules[i][j+k] == b
+				colMatch = colMatch && c.modules[j+k][i] == b
+			}
+			if rowMatch && (c.lightRun(j-4, i, j, i) || c.lightRun(j+7, i, j+11, i)) {
+				res += 40
+			}
+			if colMatch && (c.lightRun(i, j-4, i, j) || c.lightRun(i, j+7, i, j+11)) {
+				res += 40
+			}
+		}
+	}
+	// Balance of black and white modules
+	total := c.Size * c.Size
+	res += absInt(black*20-total*10) / total * 10
+	return res
+}
+
+// lightRun returns true if all modules from (x0, y0) included to (x1, y1)
+// excluded are light. Modules outside the symbol are light.
+func (c *Code) lightRun(x0, y0, x1, y1 int) bool {
+	for x, y := x0, y0; x < x1 || y < y1; {
+		if c.Black(x, y) {
+			return false
+		}
+		if x < x1 {
+			x++
+		}
+		if y < y1 {
+			y++
+		}
+	}
+	return true
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// without its leading coefficient.
+func reedSolomonDivisor(degree int) []byte {
+	res := make([]byte, degree)
+	res[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range res {
+			res[j] = gfMultiply(res[j], root)
+			if j+1 < len(res) {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return res
+}
+
+// reedSolomonRemainder returns the error correction codewords of the given data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i, coef := range divisor {
+			res[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return res
+}
+
+// gfMultiply returns the product of x and y in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// A bitBuffer is a sequence of bits
+type bitBuffer struct {
+	data []byte
+	len  int
+}
+
+// append appends the given number of low bits of value, most significant bit first
+func (bb *bitBuffer) append(value, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if bb.len%8 == 0 {
+			bb.data = append(bb.data, 0)
+		}
+		if getBit(value, i) {
+			bb.data[bb.len/8] |= 0x80 >> uint(bb.len%8)
+		}
+		bb.len++
+	}
+}
+
+// bytes returns the content of the buffer
+func (bb *bitBuffer) bytes() []byte {
+	return bb.data
+}
+
+// getBit returns true if the i-th bit of x is set
+func getBit(x, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+// minInt returns the smallest of a and b
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt returns the largest of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// absInt returns the absolute value of x
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// decode reads back the data of the given code, checking its format
+// information and its error correction codewords on the way.
+func decode(c *Code) ([]byte, bool) {
+	version := (c.Size - 17) / 4
+	vi := versions[version-1]
+	var format int
+	for i := 0; i <= 5; i++ {
+		if c.Black(8, i) {
+			format |= 1 << uint(i)
+		}
+	}
+	for i, pos := range [][2]int{{8, 7}, {8, 8}, {7, 8}} {
+		if c.Black(pos[0], pos[1]) {
+			format |= 1 << uint(6+i)
+		}
+	}
+	for i := 9; i < 15; i++ {
+		if c.Black(14-i, 8) {
+			format |= 1 << uint(i)
+		}
+	}
+	mask := (format ^ 0x5412) >> 10
+	if formatBits(mask) != format || mask > 7 {
+		return nil, false
+	}
+	// Read codewords
+	ref := newCode(version)
+	var bb bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if ref.function[y][x] {
+					continue
+				}
+				bit := c.Black(x, y) != masked(mask, x, y)
+				if bit {
+					bb.append(1, 1)
+				} else {
+					bb.append(0, 1)
+				}
+			}
+		}
+	}
+	codewords := bb.bytes()
+	// De-interleave and check error correction
+	blocks := make([][]byte, len(vi.blocks))
+	pos := 0
+	for i := 0; i < vi.blocks[len(vi.blocks)-1]; i++ {
+		for b, size := range vi.blocks {
+			if i < size {
+				blocks[b] = append(blocks[b], codewords[pos])
+				pos++
+			}
+		}
+	}
+	divisor := reedSolomonDivisor(vi.ecPerBlock)
+	var data []byte
+	for b, block := range blocks {
+		ec := make([]byte, vi.ecPerBlock)
+		for i := range ec {
+			ec[i] = codewords[pos+i*len(blocks)+b]
+		}
+		if !bytes.Equal(reedSolomonRemainder(block, divisor), ec) {
+			return nil, false
+		}
+		data = append(data, block...)
+	}
+	// Parse byte mode segment
+	if data[0]>>4 != 0x4 {
+		return nil, false
+	}
+	readBits := func(start, n int) int {
+		var res int
+		for i := start; i < start+n; i++ {
+			res = res<<1 | int(data[i/8]>>uint(7-i%8)&1)
+		}
+		return res
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	length := readBits(4, countBits)
+	res := make([]byte, length)
+	for i := range res {
+		res[i] = byte(readBits(4+countBits+8*i, 8))
+	}
+	return res, true
+}
+
+func TestReedSolomon(t *testing.T) {
+	Convey("Testing Reed-Solomon error correction", t, func() {
+		data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+		So(reedSolomonRemainder(data, reedSolomonDivisor(10)), ShouldResemble,
+			[]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23})
+	})
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	Convey("Testing format and version information", t, func() {
+		So(formatBits(0), ShouldEqual, 0x5412)
+		So(formatBits(1), ShouldEqual, 0x5125)
+		So(formatBits(2), ShouldEqual, 0x5E7C)
+		So(versionBits(7), ShouldEqual, 0x07C94)
+		So(versionBits(10), ShouldEqual, 0x0A4D3)
+	})
+}
+
+func TestEncode(t *testing.T) {
+	Convey("Testing QR Code encoding", t, func() {
+		Convey("Data should be encoded in the smallest version", func() {
+			c, err := Encode([]byte("hello"))
+			So(err, ShouldBeNil)
+			So(c.Size, ShouldEqual, 21)
+			// Finder pattern and separator
+			So(c.Black(0, 0), ShouldBeTrue)
+			So(c.Black(7, 0), ShouldBeFalse)
+			So(c.Black(3, 3), ShouldBeTrue)
+			So(c.Black(8, c.Size-8), ShouldBeTrue)
+		})
+		Convey("Encoded data should be read back for all versions", func() {
+			for _, length := range []int{1, 14, 15, 26, 42, 62, 84, 106, 122, 152, 180, 213} {
+				data := []byte(strings.Repeat("otpauth://totp/", 15)[:length])
+				c, err := Encode(data)
+				So(err, ShouldBeNil)
+				decoded, ok := decode(c)
+				So(ok, ShouldBeTrue)
+				So(decoded, ShouldResemble, data)
+			}
+		})
+		Convey("Too long data should not be encoded", func() {
+			_, err := Encode(make([]byte, 214))
+			So(err, ShouldEqual, ErrTooLong)
+		})
+		Convey("Codes should be rendered as PNG images", func() {
+			c, _ := Encode([]byte("otpauth://totp/Hexya:admin?secret=JBSWY3DPEHPK3PXP&issuer=Hexya"))
+			data, err := c.PNG(4)
+			So(err, ShouldBeNil)
+			img, err := png.Decode(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(img.Bounds().Dx(), ShouldEqual, (c.Size+2*quietZone)*4)
+		})
+	})
+}
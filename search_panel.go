@@ -0,0 +1,255 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"sort"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/views"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// searchPanelSupportedTypes lists the field types that can be used
+// in the search panel for each value of the 'select' attribute.
+var searchPanelSupportedTypes = map[string][]fieldtype.Type{
+	"one":   {fieldtype.Many2One, fieldtype.Selection},
+	"multi": {fieldtype.Many2One, fieldtype.Many2Many, fieldtype.Selection},
+}
+
+// SearchPanelSelectRange returns the values of the given many2one or selection
+// field to display as a category in the search panel.
+//
+// For many2one fields on a hierarchical model, the parent field is returned
+// and each value has its parent set, so that the client can build the tree.
+// Each value also holds the number of records matching the current domain,
+// unless DisableCounters is set.
+func commonMixin_SearchPanelSelectRange(rs m.CommonMixinSet, params webtypes.SearchPanelParams) webtypes.SearchPanelRangeResult {
+	fInfo := searchPanelFieldInfo(rs, params.FieldName, "one")
+	res := webtypes.SearchPanelRangeResult{
+		Values: []models.FieldMap{},
+	}
+	cond := searchPanelCondition(rs.Collection().Model(), params.SearchDomain, params.FilterDomain)
+	switch fInfo.Type {
+	case fieldtype.Selection:
+		res.Values = searchPanelSelectionValues(rs, params, fInfo, cond)
+	case fieldtype.Many2One:
+		comodel := rs.Env().Pool(fInfo.Relation)
+		var parentField models.FieldName
+		if _, ok := comodel.Model().Fields().Get("Parent"); ok {
+			parentField = comodel.Model().FieldName("Parent")
+			res.ParentField = parentField.JSON()
+		}
+		var counters map[interface{}]int
+		if !params.DisableCounters {
+			counters = searchPanelCounters(rs, params.FieldName, cond)
+		}
+		for _, rec := range comodel.Call("SearchAll").(models.RecordSet).Collection().Records() {
+			id := rec.Get(models.ID).(int64)
+			value := models.FieldMap{
+				"id":           id,
+				"display_name": rec.Call("NameGet").(string),
+			}
+			if !params.DisableCounters {
+				value["count"] = counters[id]
+			}
+			if parentField != nil {
+				value[parentField.JSON()] = false
+				if parent := rec.Get(parentField).(models.RecordSet).Collection(); parent.IsNotEmpty() {
+					value[parentField.JSON()] = webtypes.RecordIDWithName{
+						ID:   parent.Get(models.ID).(int64),
+						Name: parent.Call("NameGet").(string),
+					}
+				}
+			}
+			res.Values = append(res.Values, value)
+		}
+	}
+	return res
+}
+
+// SearchPanelSelectMultiRange returns the values of the given many2one, many2many or
+// selection field to display as a filter in the search panel.
+//
+// Each value holds the number of records matching the search, category and filter
+// domains, unless DisableCounters is set. If GroupBy is set, values are grouped by
+// the given field of the comodel.
+func commonMixin_SearchPanelSelectMultiRange(rs m.CommonMixinSet, params webtypes.SearchPanelParams) []models.FieldMap {
+	fInfo := searchPanelFieldInfo(rs, params.FieldName, "multi")
+	cond := searchPanelCondition(rs.Collection().Model(), params.SearchDomain, params.CategoryDomain, params.FilterDomain)
+	if fInfo.Type == fieldtype.Selection {
+		return searchPanelSelectionValues(rs, params, fInfo, cond)
+	}
+
+	comodel := rs.Env().Pool(fInfo.Relation)
+	var groupBy models.FieldName
+	var groupByInfo *models.FieldInfo
+	if params.GroupBy != "" {
+		groupBy = comodel.Model().FieldName(params.GroupBy)
+		groupByInfo = comodel.Call("FieldsGet", models.FieldsGetArgs{Fields: models.FieldNames{groupBy}}).(map[string]*models.FieldInfo)[groupBy.JSON()]
+	}
+	var counters map[interface{}]int
+	if !params.DisableCounters {
+		counters = searchPanelCounters(rs, params.FieldName, cond)
+	}
+	comodelCond := domains.ParseDomain(params.ComodelDomain, comodel.Model())
+	comodelRecs := comodel.Call("SearchAll").(models.RecordSet).Collection()
+	if !comodelCond.IsEmpty() {
+		comodelRecs = comodel.Call("Search", comodelCond).(models.RecordSet).Collection()
+	}
+	res := []models.FieldMap{}
+	for _, rec := range comodelRecs.Records() {
+		id := rec.Get(models.ID).(int64)
+		value := models.FieldMap{
+			"id":           id,
+			"display_name": rec.Call("NameGet").(string),
+		}
+		if !params.DisableCounters {
+			value["count"] = counters[id]
+		}
+		if groupBy != nil {
+			value["group_id"], value["group_name"] = searchPanelGroup(rec.Get(groupBy), groupByInfo)
+		}
+		res = append(res, value)
+	}
+	return res
+}
+
+// searchPanelFieldInfo returns the FieldInfo of the given field after checking that it
+// can be used in the search panel with the given select mode ("one" or "multi").
+func searchPanelFieldInfo(rs m.CommonMixinSet, fieldName, selectMode string) *models.FieldInfo {
+	fName := rs.Collection().Model().FieldName(fieldName)
+	fInfo := rs.FieldsGet(models.FieldsGetArgs{Fields: models.FieldNames{fName}})[fName.JSON()]
+	for _, typ := range searchPanelSupportedTypes[selectMode] {
+		if fInfo.Type == typ {
+			return fInfo
+		}
+	}
+	log.Panic("Field type not supported in search panel", "model", rs.ModelName(), "field", fieldName,
+		"type", fInfo.Type, "select", selectMode)
+	return nil
+}
+
+// searchPanelCounters returns the number of records matching the given condition
+// for each value of the given field, which must be a many2one, many2many or selection field.
+//
+// Keys of the returned map are record ids for relation fields and selection keys
+// for selection fields.
+func searchPanelCounters(rs m.CommonMixinSet, fieldName string, cond *models.Condition) map[interface{}]int {
+	fName := rs.Collection().Model().FieldName(fieldName)
+	records := rs.Search(q.CommonMixinCondition{Condition: cond})
+	if fInfo := rs.FieldsGet(models.FieldsGetArgs{Fields: models.FieldNames{fName}})[fName.JSON()]; fInfo.Type == fieldtype.Many2Many {
+		// Many2many values cannot be grouped by, so we count them
+		// from the values of all matching records, loaded at once.
+		res := make(map[interface{}]int)
+		for _, rec := range records.Collection().Records() {
+			for _, id := range rec.Get(fName).(models.RecordSet).Ids() {
+				res[id]++
+			}
+		}
+		return res
+	}
+	rSet := records.GroupBy(fName)
+	res := make(map[interface{}]int)
+	for _, ag := range rSet.Call("Aggregates", []models.FieldName{fName}).([]models.GroupAggregateRow) {
+		var key interface{}
+		switch v := ag.Values.Underlying().Get(fName).(type) {
+		case models.RecordSet:
+			if v.IsEmpty() {
+				continue
+			}
+			key = v.Ids()[0]
+		case nil:
+			continue
+		default:
+			key = v
+		}
+		res[key] = ag.Count
+	}
+	return res
+}
+
+// processSearchPanel checks the 'searchpanel' node of a search view and sets the
+// default 'select' attribute of its fields. It removes 'searchpanel' nodes found
+// in other view types, since they are only meaningful in search views.
+func processSearchPanel(rs m.CommonMixinSet, doc *etree.Document) {
+	for _, spTag := range doc.FindElements("//searchpanel") {
+		if doc.Root().Tag != string(views.ViewTypeSearch) {
+			spTag.Parent().RemoveChild(spTag)
+			continue
+		}
+		for _, fieldTag := range spTag.SelectElements("field") {
+			selectMode := fieldTag.SelectAttrValue("select", "one")
+			fieldTag.CreateAttr("select", selectMode)
+			searchPanelFieldInfo(rs, fieldTag.SelectAttrValue("name", ""), selectMode)
+		}
+	}
+}
+
+// searchPanelCondition returns a condition matching the records that
+// match all the given domains.
+func searchPanelCondition(model *models.Model, doms ...domains.Domain) *models.Condition {
+	cond := &models.Condition{}
+	for _, dom := range doms {
+		cond = cond.AndCond(domains.ParseDomain(dom, model))
+	}
+	return cond
+}
+
+// searchPanelSelectionValues returns the search panel values of the selection field
+// described by fInfo, with their counters if they are not disabled in params.
+func searchPanelSelectionValues(rs m.CommonMixinSet, params webtypes.SearchPanelParams, fInfo *models.FieldInfo, cond *models.Condition) []models.FieldMap {
+	var counters map[interface{}]int
+	if !params.DisableCounters {
+		counters = searchPanelCounters(rs, params.FieldName, cond)
+	}
+	keys := make([]string, 0, len(fInfo.Selection))
+	for key := range fInfo.Selection {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := make([]models.FieldMap, len(keys))
+	for i, key := range keys {
+		res[i] = models.FieldMap{
+			"id":           key,
+			"display_name": fInfo.Selection[key],
+		}
+		if !params.DisableCounters {
+			res[i]["count"] = counters[key]
+		}
+	}
+	return res
+}
+
+// searchPanelGroup returns the group id and group name to use in the search panel
+// for the given value of a group by field described by fInfo.
+func searchPanelGroup(value interface{}, fInfo *models.FieldInfo) (interface{}, string) {
+	switch v := value.(type) {
+	case models.RecordSet:
+		rc := v.Collection()
+		if rc.IsEmpty() {
+			return false, ""
+		}
+		return rc.Get(models.ID), rc.Call("NameGet").(string)
+	case string:
+		if fInfo.Type == fieldtype.Selection {
+			return v, fInfo.Selection[v]
+		}
+		return v, v
+	case nil:
+		return false, ""
+	}
+	return value, ""
+}
+
+func init() {
+	h.CommonMixin().NewMethod("SearchPanelSelectRange", commonMixin_SearchPanelSelectRange)
+	h.CommonMixin().NewMethod("SearchPanelSelectMultiRange", commonMixin_SearchPanelSelectMultiRange)
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"testing"
+
+	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+	"github.com/hexya-erp/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var searchPanelView = `
+<search>
+	<field name="name"/>
+	<searchpanel>
+		<field name="company_id"/>
+		<field name="chatter_position" select="multi"/>
+	</searchpanel>
+</search>
+`
+
+var searchPanelFormView = `
+<form>
+	<field name="name"/>
+	<searchpanel>
+		<field name="company_id"/>
+	</searchpanel>
+</form>
+`
+
+var searchPanelFieldInfos = map[string]*models.FieldInfo{
+	"name":             {},
+	"company_id":       {},
+	"chatter_position": {},
+}
+
+func TestSearchPanel(t *testing.T) {
+	Convey("Testing search panel", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			mainCompany := h.Company().NewSet(env).GetRecord("base_main_company")
+			Convey("searchpanel nodes should be kept in search views with their select mode", func() {
+				v, _ := xmlutils.XMLToDocument(searchPanelView)
+				view := h.User().NewSet(env).ProcessView(v, searchPanelFieldInfos)
+				So(view, ShouldContainSubstring, `<searchpanel>`)
+				So(view, ShouldContainSubstring, `<field name="company_id" select="one"`)
+				So(view, ShouldContainSubstring, `<field name="chatter_position" select="multi"`)
+			})
+			Convey("searchpanel nodes should be removed from other views", func() {
+				v, _ := xmlutils.XMLToDocument(searchPanelFormView)
+				view := h.User().NewSet(env).ProcessView(v, searchPanelFieldInfos)
+				So(view, ShouldNotContainSubstring, `searchpanel`)
+				So(view, ShouldContainSubstring, `<field name="name"`)
+			})
+			Convey("Unsupported field types should panic", func() {
+				So(func() {
+					h.User().NewSet(env).SearchPanelSelectRange(webtypes.SearchPanelParams{FieldName: "login"})
+				}, ShouldPanic)
+				So(func() {
+					h.User().NewSet(env).SearchPanelSelectRange(webtypes.SearchPanelParams{FieldName: "group_ids"})
+				}, ShouldPanic)
+				So(func() {
+					h.User().NewSet(env).SearchPanelSelectMultiRange(webtypes.SearchPanelParams{FieldName: "group_ids"})
+				}, ShouldNotPanic)
+			})
+			Convey("Selecting range of a many2one field", func() {
+				res := h.User().NewSet(env).SearchPanelSelectRange(webtypes.SearchPanelParams{
+					FieldName: "company_id",
+				})
+				So(res.ParentField, ShouldEqual, "parent_id")
+				var found bool
+				for _, value := range res.Values {
+					if value["id"] != mainCompany.ID() {
+						continue
+					}
+					found = true
+					So(value["display_name"], ShouldEqual, "Your Company")
+					So(value["count"], ShouldBeGreaterThan, 0)
+					So(value["parent_id"], ShouldBeFalse)
+				}
+				So(found, ShouldBeTrue)
+			})
+			Convey("Selecting multi range of a selection field", func() {
+				h.User().NewSet(env).SearchAll().SetChatterPosition("sided")
+				res := h.User().NewSet(env).SearchPanelSelectMultiRange(webtypes.SearchPanelParams{
+					FieldName: "chatter_position",
+				})
+				So(res, ShouldHaveLength, 2)
+				So(res[0]["id"], ShouldEqual, "normal")
+				So(res[0]["display_name"], ShouldEqual, "Normal")
+				So(res[0]["count"], ShouldEqual, 0)
+				So(res[1]["id"], ShouldEqual, "sided")
+				So(res[1]["count"], ShouldEqual, h.User().NewSet(env).SearchAll().Len())
+			})
+			Convey("Counters should take search domain into account", func() {
+				res := h.User().NewSet(env).SearchPanelSelectMultiRange(webtypes.SearchPanelParams{
+					FieldName:    "company_id",
+					SearchDomain: domains.Domain{[]interface{}{"login", "=", "admin"}},
+				})
+				for _, value := range res {
+					if value["id"] != mainCompany.ID() {
+						continue
+					}
+					So(value["count"], ShouldEqual, 1)
+				}
+			})
+			Convey("Counters of many2many fields should count each related record", func() {
+				admin := h.User().Search(env, q.User().Login().Equals("admin"))
+				adminGroups := make(map[int64]bool)
+				for _, id := range admin.Groups().Ids() {
+					adminGroups[id] = true
+				}
+				res := h.User().NewSet(env).SearchPanelSelectMultiRange(webtypes.SearchPanelParams{
+					FieldName:    "group_ids",
+					SearchDomain: domains.Domain{[]interface{}{"login", "=", "admin"}},
+				})
+				So(res, ShouldNotBeEmpty)
+				for _, value := range res {
+					if adminGroups[value["id"].(int64)] {
+						So(value["count"], ShouldEqual, 1)
+						continue
+					}
+					So(value["count"], ShouldEqual, 0)
+				}
+			})
+			Convey("Counters can be disabled", func() {
+				res := h.User().NewSet(env).SearchPanelSelectMultiRange(webtypes.SearchPanelParams{
+					FieldName:       "company_id",
+					DisableCounters: true,
+				})
+				So(res, ShouldNotBeEmpty)
+				So(res[0], ShouldNotContainKey, "count")
+			})
+			Convey("Counters of categories can be disabled", func() {
+				res := h.User().NewSet(env).SearchPanelSelectRange(webtypes.SearchPanelParams{
+					FieldName:       "company_id",
+					DisableCounters: true,
+				})
+				So(res.Values, ShouldNotBeEmpty)
+				So(res.Values[0], ShouldNotContainKey, "count")
+			})
+		}), ShouldBeNil)
+	})
+}
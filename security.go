@@ -4,10 +4,47 @@
 package web
 
 import (
+	"github.com/hexya-addons/base"
+	"github.com/hexya-erp/hexya/src/models"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/pool/h"
 )
 
 func init() {
 	h.Filter().Methods().AllowAllToGroup(security.GroupEveryone)
+	h.ExportTemplate().Methods().AllowAllToGroup(security.GroupEveryone)
+	h.ExportTemplateLine().Methods().AllowAllToGroup(security.GroupEveryone)
+	h.ImportWizard().Methods().AllowAllToGroup(security.GroupEveryone)
+	h.UserSession().Methods().Load().AllowGroup(security.GroupEveryone)
+	h.UserSession().Methods().Revoke().AllowGroup(security.GroupEveryone)
+	h.UserSession().Methods().RevokeOtherSessions().AllowGroup(security.GroupEveryone)
+	h.UserSession().Methods().OpenSession().RevokeGroup(security.GroupEveryone)
+	h.UserSession().Methods().FindSession().RevokeGroup(security.GroupEveryone)
+	h.UserSession().Methods().Touch().RevokeGroup(security.GroupEveryone)
+	h.UserSession().Methods().IsExpired().RevokeGroup(security.GroupEveryone)
+	h.UserSession().Methods().RemoveExpiredSessions().RevokeGroup(security.GroupEveryone)
+	h.APIKey().Methods().Load().AllowGroup(security.GroupEveryone)
+	h.APIKey().Methods().FindUser().RevokeGroup(security.GroupEveryone)
+	h.LoginAttempt().Methods().Load().AllowGroup(base.GroupSystem)
+	h.LoginAttempt().Methods().CheckLogin().RevokeGroup(security.GroupEveryone)
+	h.LoginAttempt().Methods().RegisterFailure().RevokeGroup(security.GroupEveryone)
+	h.LoginAttempt().Methods().ClearLogin().RevokeGroup(security.GroupEveryone)
+	h.LoginAttempt().Methods().RemoveOldAttempts().RevokeGroup(security.GroupEveryone)
+	h.User().Methods().UnlockLogin().RevokeGroup(security.GroupEveryone).AllowGroup(base.GroupSystem)
+	h.User().Methods().TOTPEnroll().AllowGroup(security.GroupEveryone)
+	h.User().Methods().TOTPConfirm().AllowGroup(security.GroupEveryone)
+	h.User().Methods().TOTPVerify().RevokeGroup(security.GroupEveryone)
+	h.User().Methods().TOTPReset().RevokeGroup(security.GroupEveryone).AllowGroup(base.GroupSystem)
+	h.User().Methods().CheckPasswordPolicy().RevokeGroup(security.GroupEveryone)
+	h.User().Methods().RequestPasswordReset().RevokeGroup(security.GroupEveryone)
+	h.User().Methods().ResetPassword().RevokeGroup(security.GroupEveryone)
+
+	restrictFieldAccess("User", []models.FieldName{
+		h.User().Fields().Password(),
+		h.User().Fields().TOTPSecret(),
+		h.User().Fields().TOTPPendingSecret(),
+		h.User().Fields().TOTPLastStep(),
+		h.User().Fields().TOTPRecoveryCodes(),
+		h.User().Fields().PasswordHistory(),
+	})
 }
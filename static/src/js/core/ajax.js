@@ -119,11 +119,16 @@ function _genericJsonRpc (fct_name, params, settings, fct) {
 
 function jsonRpc(url, fct_name, params, settings) {
     settings = settings || {};
+    var headers = _.extend({}, settings.headers);
+    if (core.csrf_token) {
+        headers['X-CSRF-Token'] = core.csrf_token;
+    }
     return _genericJsonRpc(fct_name, params, settings, function(data) {
         return $.ajax(url, _.extend({}, settings, {
             url: url,
             dataType: 'json',
             type: 'POST',
+            headers: headers,
             data: JSON.stringify(data, time.date_to_utc),
             contentType: 'application/json'
         }));
@@ -256,6 +261,7 @@ function get_file(options) {
     }
     data.append('token', 'dummy-because-api-expects-one');
     if (core.csrf_token) {
+        xhr.setRequestHeader('X-CSRF-Token', core.csrf_token);
         data.append('csrf_token', core.csrf_token);
     }
     // IE11 wants this after xhr.open or it throws
@@ -333,6 +339,7 @@ function post (controller_url, data) {
 
     return new Promise(function (resolve, reject) {
         $.ajax(controller_url, {
+            headers: core.csrf_token ? {'X-CSRF-Token': core.csrf_token} : {},
             data: postData,
             processData: false,
             contentType: false,
@@ -360,7 +360,14 @@ var Session = core.Class.extend(mixins.EventDispatcherMixin, {
         }).join(',');
         utils.set_cookie('cids', hash.cids || String(main_company_id));
         $.bbq.pushState({'cids': hash.cids}, 0);
-        location.reload();
+        var reload = function () {
+            location.reload();
+        };
+        // Keep the selection in the server session for the calls without context
+        return this.rpc('/web/session/switch_company', {
+            company_id: main_company_id,
+            allowed_company_ids: company_ids,
+        }).then(reload, reload);
     },
 
     //--------------------------------------------------------------------------
@@ -4,7 +4,6 @@ hexya.define('web.DataExport', function (require) {
 var config = require('web.config');
 var core = require('web.core');
 var Dialog = require('web.Dialog');
-var data = require('web.data');
 var framework = require('web.framework');
 var pyUtils = require('web.py_utils');
 
@@ -47,7 +46,6 @@ var DataExport = Dialog.extend({
         this.record = record;
         this.defaultExportFields = defaultExportFields;
         this.groupby = groupedBy;
-        this.exports = new data.DataSetSearch(this, 'ir.exports', this.record.getContext());
         this.rowIndex = 0;
         this.rowIndexLevel = 0;
         this.isCompatibleMode = false;
@@ -334,9 +332,8 @@ var DataExport = Dialog.extend({
 
         return this._rpc({
             model: 'ir.exports',
-            method: 'search_read',
-            fields: ['name'],
-            domain: [['resource', '=', this.record.model]]
+            method: 'get_templates',
+            args: [this.record.model],
         }).then(function (exportList) {
             self.$('.o_exported_lists').append(QWeb.render('Export.SavedList', {
                 existing_exports: exportList,
@@ -431,7 +428,11 @@ var DataExport = Dialog.extend({
         var options = {
             confirm_callback: function () {
                 if (selectExp.val()) {
-                    self.exports.unlink([parseInt(selectExp.val(), 10)]);
+                    self._rpc({
+                        model: 'ir.exports',
+                        method: 'delete_template',
+                        args: [[parseInt(selectExp.val(), 10)]],
+                    });
                     selectExp.remove();
                     if (self.$('.o_exported_lists_select option').length <= 1) {
                         self.$('.o_exported_lists').hide();
@@ -459,8 +460,8 @@ var DataExport = Dialog.extend({
         this._resetTemplateField();
     },
     /**
-     * This method will create a record in 'ir.exports' model with list of
-     * selected fields.
+     * This method will save an export template in 'ir.exports' model with
+     * the list of selected fields.
      *
      * @private
      */
@@ -481,12 +482,10 @@ var DataExport = Dialog.extend({
 
         $saveList.hide();
 
-        this.exports.create({
-            name: value,
-            resource: this.record.model,
-            export_fields: _.map(fields, function (field) {
-                return [0, 0, { name: field }];
-            }),
+        this._rpc({
+            model: 'ir.exports',
+            method: 'save_template',
+            args: [value, this.record.model, fields],
         }).then(function (exportListID) {
             if (!exportListID) {
                 return;
@@ -4,7 +4,6 @@ hexya.define('web.data_export_tests', function (require) {
 var framework = require('web.framework');
 var ListView = require('web.ListView');
 var testUtils = require('web.test_utils');
-var data = require('web.data');
 
 var createView = testUtils.createView;
 
@@ -65,7 +64,10 @@ QUnit.module('widgets', {
             viewOptions: {
                 hasSidebar: true,
             },
-            mockRPC: function (route) {
+            mockRPC: function (route, args) {
+                if (args.method === 'get_templates') {
+                    return Promise.resolve([]);
+                }
                 if (route === '/web/export/formats') {
                     return Promise.resolve([
                         {tag: 'csv', label: 'CSV'},
@@ -129,14 +131,7 @@ QUnit.module('widgets', {
     });
 
     QUnit.test('saving fields list when exporting data', async function (assert) {
-        assert.expect(4);
-
-        var create = data.DataSet.prototype.create;
-
-        data.DataSet.prototype.create = function (data, options) {
-            assert.step('create');
-            return Promise.resolve([]);
-        };
+        assert.expect(5);
 
         var list = await createView({
             View: ListView,
@@ -146,7 +141,16 @@ QUnit.module('widgets', {
             viewOptions: {
                 hasSidebar: true,
             },
-            mockRPC: function (route) {
+            mockRPC: function (route, args) {
+                if (args.method === 'get_templates') {
+                    return Promise.resolve([]);
+                }
+                if (args.method === 'save_template') {
+                    assert.step('save_template');
+                    assert.deepEqual(args.args, ['fields list', 'partner', ['foo', 'activity_ids']],
+                        "the template name, model and fields should be given");
+                    return Promise.resolve(false);
+                }
                 if (route === '/web/export/formats') {
                     return Promise.resolve([
                         {tag: 'csv', label: 'CSV'},
@@ -195,15 +199,12 @@ QUnit.module('widgets', {
         await testUtils.fields.editInput($('.modal .o_save_list .o_save_list_name'), 'fields list');
         await testUtils.dom.click($('.modal .o_save_list .o_save_list_btn'));
 
-        assert.verifySteps(['create'],
-            "create should have been called");
+        assert.verifySteps(['save_template'],
+            "save_template should have been called");
 
         // Close the modal and destroy list
         await testUtils.dom.click($('.modal button span:contains(Close)'));
         list.destroy();
-
-        // restore create function
-        data.DataSet.prototype.create = create;
     });
 
     QUnit.test('Export dialog UI test', async function (assert) {
@@ -216,7 +217,10 @@ QUnit.module('widgets', {
             viewOptions: {
                 hasSidebar: true,
             },
-            mockRPC: function (route) {
+            mockRPC: function (route, args) {
+                if (args.method === 'get_templates') {
+                    return Promise.resolve([]);
+                }
                 if (route === '/web/export/formats') {
                     return Promise.resolve([
                         {tag: 'csv', label: 'CSV' },
@@ -9,6 +9,7 @@ import (
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/pool/h"
 	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
 )
 
 var fields_User = map[string]models.FieldDefinition{
@@ -17,6 +18,22 @@ var fields_User = map[string]models.FieldDefinition{
 		String:    "Chatter Position", Default: models.DefaultValue("sided"),
 	},
 	"SidebarVisible": fields.Boolean{String: "Show App Sidebar", Default: models.DefaultValue(true)},
+	"Sessions": fields.One2Many{RelationModel: h.UserSession(), ReverseFK: "User",
+		String: "Active Sessions", JSON: "session_ids", NoCopy: true},
+	"APIKeys": fields.One2Many{RelationModel: h.APIKey(), ReverseFK: "User",
+		String: "API Keys", JSON: "api_key_ids", NoCopy: true},
+	"TOTPSecret": fields.Char{String: "TOTP Secret", JSON: "totp_secret", NoCopy: true,
+		Help: "Base32 secret of the enrolled authenticator app"},
+	"TOTPPendingSecret": fields.Char{String: "Pending TOTP Secret", JSON: "totp_pending_secret", NoCopy: true,
+		Help: "Secret being enrolled, waiting for a first code to be verified"},
+	"TOTPLastStep": fields.Integer{String: "Last TOTP Time Step", JSON: "totp_last_step", NoCopy: true,
+		Help: "Time step of the last accepted code, so that codes cannot be used twice"},
+	"TOTPRecoveryCodes": fields.Text{String: "TOTP Recovery Codes", JSON: "totp_recovery_codes", NoCopy: true,
+		Help: "Hashes of the unused recovery codes, one per line"},
+	"TOTPEnabled": fields.Boolean{String: "Two-factor Authentication", JSON: "totp_enabled",
+		Compute: h.User().Methods().ComputeTOTPEnabled(), Depends: []string{"TOTPSecret"}},
+	"PasswordHistory": fields.Text{String: "Password History", NoCopy: true,
+		Help: "Hashes of the previous passwords, most recent first, one per line"},
 }
 
 func user_SelfWritableFields(rs m.UserSet) map[string]bool {
@@ -30,6 +47,9 @@ func user_SelfReadableFields(rs m.UserSet) map[string]bool {
 	res := rs.Super().SelfReadableFields()
 	res["ChatterPosition"] = true
 	res["SidebarVisible"] = true
+	res["Sessions"] = true
+	res["APIKeys"] = true
+	res["TOTPEnabled"] = true
 	return res
 }
 
@@ -39,9 +59,29 @@ func user_ContextGet(rs m.UserSet) *types.Context {
 	return res
 }
 
+// ActiveCompanies returns the companies in which the current user is working,
+// as set in the allowed_company_ids key of the context by the web client, so
+// that record rules and methods can be restricted to them.
+//
+// Companies of which the current user is not a member are ignored. If none is
+// left, the default company of the current user is returned.
+func user_ActiveCompanies(rs m.UserSet) m.CompanySet {
+	user := h.User().NewSet(rs.Env()).CurrentUser().Sudo()
+	res := h.Company().NewSet(rs.Env())
+	if ids := rs.Env().Context().GetIntegerSlice("allowed_company_ids"); len(ids) > 0 {
+		res = h.Company().Search(rs.Env(), q.Company().ID().In(ids).
+			And().ID().In(user.Company().Union(user.Companies()).Ids()))
+	}
+	if res.IsEmpty() {
+		res = user.Company()
+	}
+	return res
+}
+
 func init() {
 	h.User().AddFields(fields_User)
 	h.User().Methods().SelfWritableFields().Extend(user_SelfWritableFields)
 	h.User().Methods().SelfReadableFields().Extend(user_SelfReadableFields)
 	h.User().Methods().ContextGet().Extend(user_ContextGet)
+	h.User().NewMethod("ActiveCompanies", user_ActiveCompanies)
 }
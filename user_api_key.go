@@ -0,0 +1,162 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// API key scopes
+const (
+	// APIKeyScopeRPC keys give access to all the RPC routes of the web client
+	// and can be used instead of the password to authenticate.
+	APIKeyScopeRPC = "rpc"
+	// APIKeyScopeDataset keys only give access to the dataset routes.
+	APIKeyScopeDataset = "dataset"
+)
+
+var fields_APIKey = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Help: "Description of the key, for instance the name of the integration using it"},
+	"KeyHash": fields.Char{String: "Key Hash", Required: true, Unique: true, Index: true, NoCopy: true,
+		Help: "SHA-256 hash of the key, which is only shown once at creation"},
+	"User": fields.Many2One{RelationModel: h.User(), Required: true, Index: true, OnDelete: models.Cascade},
+	"Scope": fields.Selection{Selection: types.Selection{
+		APIKeyScopeRPC:     "All RPC Calls",
+		APIKeyScopeDataset: "Model Data Only",
+	}, Required: true, Default: models.DefaultValue(APIKeyScopeRPC)},
+	"ExpirationDate": fields.DateTime{String: "Expiration Date", Help: "Leave empty for keys that never expire"},
+	"LastUse":        fields.DateTime{String: "Last Use"},
+}
+
+// newAPIKey returns a new random API key
+func newAPIKey() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("unable to generate API key: %s", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// GenerateKey creates a new API key for the current user with the given name,
+// scope and expiration date, and returns the key.
+//
+// Only the hash of the key is stored, so that the key cannot be shown again.
+func apiKey_GenerateKey(rs m.APIKeySet, name, scope string, expirationDate dates.DateTime) string {
+	if scope != APIKeyScopeRPC && scope != APIKeyScopeDataset {
+		log.Panic(rs.T("Invalid API key scope: %s", scope))
+	}
+	if !expirationDate.IsZero() && expirationDate.Lower(dates.Now()) {
+		log.Panic(rs.T("The expiration date of an API key must be in the future"))
+	}
+	key := newAPIKey()
+	h.APIKey().NewSet(rs.Env()).Sudo().Create(h.APIKey().NewData().
+		SetName(name).
+		SetKeyHash(hashSessionKey(key)).
+		SetUser(h.User().BrowseOne(rs.Env(), rs.Env().Uid())).
+		SetScope(scope).
+		SetExpirationDate(expirationDate))
+	return key
+}
+
+// FindUser returns the ID of the user of the given API key if the key gives
+// access to the given scope, or 0 if the key is unknown, revoked or expired.
+//
+// Keys of the 'rpc' scope give access to all scopes.
+func apiKey_FindUser(rs m.APIKeySet, key, scope string) int64 {
+	if key == "" {
+		return 0
+	}
+	apiKey := h.APIKey().NewSet(rs.Env()).Sudo().Search(q.APIKey().KeyHash().Equals(hashSessionKey(key)))
+	switch {
+	case apiKey.IsEmpty():
+		return 0
+	case apiKey.Scope() != APIKeyScopeRPC && apiKey.Scope() != scope:
+		return 0
+	case !apiKey.ExpirationDate().IsZero() && apiKey.ExpirationDate().Lower(dates.Now()):
+		return 0
+	case !apiKey.User().Active():
+		return 0
+	}
+	if time.Since(apiKey.LastUse().Time) > sessionActivityInterval {
+		apiKey.SetLastUse(dates.Now())
+	}
+	return apiKey.User().ID()
+}
+
+// Revoke deletes the API keys of this RecordSet, which cannot be used anymore.
+//
+// Users can only revoke their own keys, unless they are administrators.
+func apiKey_Revoke(rs m.APIKeySet) bool {
+	isSystem := rs.Env().Uid() == security.SuperUserID || h.User().NewSet(rs.Env()).CurrentUser().IsSystem()
+	for _, apiKey := range rs.Sudo().Records() {
+		if !isSystem && apiKey.User().ID() != rs.Env().Uid() {
+			log.Panic(rs.T("You cannot revoke the API keys of other users"), "api_key", apiKey.ID())
+		}
+	}
+	rs.Sudo().Unlink()
+	return true
+}
+
+// Search extends the standard method so that users other
+// than administrators only get their own API keys.
+func apiKey_Search(rs m.APIKeySet, cond q.APIKeyCondition) m.APIKeySet {
+	if rs.Env().Uid() != security.SuperUserID && !h.User().NewSet(rs.Env()).CurrentUser().IsSystem() {
+		cond = cond.AndCond(q.APIKey().UserFilteredOn(q.User().ID().Equals(rs.Env().Uid())))
+	}
+	return rs.Super().Search(cond)
+}
+
+// An apiKeyAuthBackend authenticates users with one of their API keys of
+// the 'rpc' scope given as password, for external API clients.
+//
+// API keys are refused for interactive logins, which are marked by the
+// 'interactive' key of the authentication context, so that they cannot
+// be used to log in the web client without two-factor authentication.
+type apiKeyAuthBackend struct{}
+
+// Authenticate the user defined by login with an API key as secret.
+//
+// It returns a UserNotFoundError if secret is not an API key of this user
+// so that the next backends can check it as a password.
+func (apiKeyAuthBackend) Authenticate(login, secret string, context *types.Context) (int64, error) {
+	if context.GetBool("interactive") {
+		return 0, security.UserNotFoundError(login)
+	}
+	var uid int64
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		keyUID := h.APIKey().NewSet(env).FindUser(secret, APIKeyScopeRPC)
+		if keyUID != 0 && h.User().BrowseOne(env, keyUID).Login() == login {
+			uid = keyUID
+		}
+	})
+	if err != nil || uid == 0 {
+		return 0, security.UserNotFoundError(login)
+	}
+	return uid, nil
+}
+
+var _ security.AuthBackend = apiKeyAuthBackend{}
+
+func init() {
+	models.NewModel("APIKey")
+	h.APIKey().AddFields(fields_APIKey)
+	h.APIKey().NewMethod("GenerateKey", apiKey_GenerateKey)
+	h.APIKey().NewMethod("FindUser", apiKey_FindUser)
+	h.APIKey().NewMethod("Revoke", apiKey_Revoke)
+	h.APIKey().Methods().Search().Extend(apiKey_Search)
+
+	security.AuthenticationRegistry.RegisterBackend(apiKeyAuthBackend{})
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAPIKeys(t *testing.T) {
+	Convey("Testing API keys", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			admin := h.User().Search(env, q.User().Login().Equals("admin"))
+			demoKeys := h.APIKey().NewSet(env).Sudo(demo.ID())
+			rpcKey := demoKeys.GenerateKey("Integration", APIKeyScopeRPC, dates.DateTime{})
+			datasetKey := demoKeys.GenerateKey("Reporting", APIKeyScopeDataset, dates.Now().Add(time.Hour))
+			adminKey := h.APIKey().NewSet(env).Sudo(admin.ID()).GenerateKey("Admin", APIKeyScopeRPC, dates.DateTime{})
+			apiKeys := h.APIKey().NewSet(env)
+			Convey("Keys should be stored hashed", func() {
+				So(rpcKey, ShouldHaveLength, 64)
+				So(apiKeys.Search(q.APIKey().KeyHash().Equals(rpcKey)).IsEmpty(), ShouldBeTrue)
+				So(apiKeys.Search(q.APIKey().KeyHash().Equals(hashSessionKey(rpcKey))).Len(), ShouldEqual, 1)
+			})
+			Convey("Keys should give access to their scope only", func() {
+				So(apiKeys.FindUser(rpcKey, APIKeyScopeRPC), ShouldEqual, demo.ID())
+				So(apiKeys.FindUser(rpcKey, APIKeyScopeDataset), ShouldEqual, demo.ID())
+				So(apiKeys.FindUser(datasetKey, APIKeyScopeDataset), ShouldEqual, demo.ID())
+				So(apiKeys.FindUser(datasetKey, APIKeyScopeRPC), ShouldEqual, 0)
+				So(apiKeys.FindUser("unknown-key", APIKeyScopeRPC), ShouldEqual, 0)
+				So(apiKeys.FindUser("", APIKeyScopeRPC), ShouldEqual, 0)
+			})
+			Convey("Expired keys should be refused", func() {
+				So(func() {
+					demoKeys.GenerateKey("Expired", APIKeyScopeRPC, dates.Now().Add(-time.Hour))
+				}, ShouldPanic)
+				apiKeys.Search(q.APIKey().Name().Equals("Reporting")).SetExpirationDate(dates.Now().Add(-time.Minute))
+				So(apiKeys.FindUser(datasetKey, APIKeyScopeDataset), ShouldEqual, 0)
+			})
+			Convey("Users should only see and revoke their own keys", func() {
+				So(demoKeys.SearchAll().Len(), ShouldEqual, 2)
+				adminKeyRec := apiKeys.Search(q.APIKey().Name().Equals("Admin"))
+				So(func() { adminKeyRec.Sudo(demo.ID()).Revoke() }, ShouldPanic)
+				So(demoKeys.Search(q.APIKey().Name().Equals("Integration")).Revoke(), ShouldBeTrue)
+				So(apiKeys.FindUser(rpcKey, APIKeyScopeRPC), ShouldEqual, 0)
+				So(apiKeys.FindUser(adminKey, APIKeyScopeRPC), ShouldEqual, admin.ID())
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fields"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+	"github.com/hexya-erp/pool/q"
+)
+
+// sessionActivityInterval is the minimum interval between two
+// updates of the last activity date of a session.
+const sessionActivityInterval = time.Minute
+
+var fields_UserSession = map[string]models.FieldDefinition{
+	"KeyHash": fields.Char{String: "Session Key Hash", Required: true, Unique: true, Index: true, NoCopy: true,
+		Help: "SHA-256 hash of the session key stored in the client cookie"},
+	"User":         fields.Many2One{RelationModel: h.User(), Required: true, Index: true, OnDelete: models.Cascade},
+	"IPAddress":    fields.Char{String: "IP Address", JSON: "ip_address"},
+	"UserAgent":    fields.Char{String: "User Agent"},
+	"LastActivity": fields.DateTime{String: "Last Activity"},
+}
+
+// hashSessionKey returns the hash of the given session key,
+// which is the value stored in the database.
+func hashSessionKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// OpenSession registers a new session with the given key for the given user,
+// opened from the given IP address and user agent.
+func userSession_OpenSession(rs m.UserSessionSet, user m.UserSet, key, ipAddress, userAgent string) m.UserSessionSet {
+	user.EnsureOne()
+	return h.UserSession().NewSet(rs.Env()).Sudo().Create(h.UserSession().NewData().
+		SetKeyHash(hashSessionKey(key)).
+		SetUser(user).
+		SetIPAddress(ipAddress).
+		SetUserAgent(userAgent).
+		SetLastActivity(dates.Now()))
+}
+
+// FindSession returns the session with the given key, or an empty
+// RecordSet if the key is unknown or the session has been revoked.
+func userSession_FindSession(rs m.UserSessionSet, key string) m.UserSessionSet {
+	if key == "" {
+		return h.UserSession().NewSet(rs.Env())
+	}
+	return h.UserSession().NewSet(rs.Env()).Sudo().Search(q.UserSession().KeyHash().Equals(hashSessionKey(key)))
+}
+
+// Touch updates the last activity date and the IP address of this session.
+//
+// The date is only written if the previous activity is older than a minute,
+// so that each request does not write to the database.
+func userSession_Touch(rs m.UserSessionSet, ipAddress string) {
+	rs.EnsureOne()
+	if time.Since(rs.LastActivity().Time) < sessionActivityInterval && rs.IPAddress() == ipAddress {
+		return
+	}
+	rs.Sudo().Write(h.UserSession().NewData().
+		SetLastActivity(dates.Now()).
+		SetIPAddress(ipAddress))
+}
+
+// IsExpired returns true if this session has been inactive for more than
+// idleTimeout or has been opened for more than lifetime.
+//
+// A zero idleTimeout or lifetime disables the corresponding check.
+func userSession_IsExpired(rs m.UserSessionSet, idleTimeout, lifetime time.Duration) bool {
+	rs.EnsureOne()
+	if idleTimeout > 0 && time.Since(rs.LastActivity().Time) > idleTimeout {
+		return true
+	}
+	if lifetime > 0 && time.Since(rs.CreateDate().Time) > lifetime {
+		return true
+	}
+	return false
+}
+
+// RemoveExpiredSessions deletes the sessions of all users that have expired
+// given idleTimeout and lifetime, and returns the number of deleted sessions.
+//
+// A zero idleTimeout or lifetime disables the corresponding check.
+func userSession_RemoveExpiredSessions(rs m.UserSessionSet, idleTimeout, lifetime time.Duration) int {
+	idleCond := q.UserSession().LastActivity().Lower(dates.Now().Add(-idleTimeout))
+	lifetimeCond := q.UserSession().CreateDate().Lower(dates.Now().Add(-lifetime))
+	var cond q.UserSessionCondition
+	switch {
+	case idleTimeout > 0 && lifetime > 0:
+		cond = idleCond.OrCond(lifetimeCond)
+	case idleTimeout > 0:
+		cond = idleCond
+	case lifetime > 0:
+		cond = lifetimeCond
+	default:
+		return 0
+	}
+	expired := h.UserSession().NewSet(rs.Env()).Sudo().Search(cond)
+	res := expired.Len()
+	expired.Unlink()
+	return res
+}
+
+// Revoke closes the sessions of this RecordSet. The users of these
+// sessions are logged out at their next request.
+//
+// Users can only revoke their own sessions, unless they are administrators.
+func userSession_Revoke(rs m.UserSessionSet) bool {
+	isSystem := rs.Env().Uid() == security.SuperUserID || h.User().NewSet(rs.Env()).CurrentUser().IsSystem()
+	for _, sess := range rs.Sudo().Records() {
+		if !isSystem && sess.User().ID() != rs.Env().Uid() {
+			log.Panic(rs.T("You cannot revoke the sessions of other users"), "session", sess.ID())
+		}
+	}
+	rs.Sudo().Unlink()
+	return true
+}
+
+// RevokeOtherSessions revokes all the sessions of the current user except the one with
+// the given key, logging the user out of all other devices. It returns the number
+// of revoked sessions.
+func userSession_RevokeOtherSessions(rs m.UserSessionSet, key string) int {
+	others := h.UserSession().NewSet(rs.Env()).Sudo().Search(
+		q.UserSession().UserFilteredOn(q.User().ID().Equals(rs.Env().Uid())).
+			And().KeyHash().NotEquals(hashSessionKey(key)))
+	res := others.Len()
+	others.Unlink()
+	return res
+}
+
+// Search extends the standard method so that users other
+// than administrators only get their own sessions.
+func userSession_Search(rs m.UserSessionSet, cond q.UserSessionCondition) m.UserSessionSet {
+	if rs.Env().Uid() != security.SuperUserID && !h.User().NewSet(rs.Env()).CurrentUser().IsSystem() {
+		cond = cond.AndCond(q.UserSession().UserFilteredOn(q.User().ID().Equals(rs.Env().Uid())))
+	}
+	return rs.Super().Search(cond)
+}
+
+func init() {
+	models.NewModel("UserSession")
+	h.UserSession().AddFields(fields_UserSession)
+	h.UserSession().NewMethod("OpenSession", userSession_OpenSession)
+	h.UserSession().NewMethod("FindSession", userSession_FindSession)
+	h.UserSession().NewMethod("Touch", userSession_Touch)
+	h.UserSession().NewMethod("IsExpired", userSession_IsExpired)
+	h.UserSession().NewMethod("RemoveExpiredSessions", userSession_RemoveExpiredSessions)
+	h.UserSession().NewMethod("Revoke", userSession_Revoke)
+	h.UserSession().NewMethod("RevokeOtherSessions", userSession_RevokeOtherSessions)
+	h.UserSession().Methods().Search().Extend(userSession_Search)
+}
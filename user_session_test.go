@@ -0,0 +1,80 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/hexya/src/models/types/dates"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUserSessions(t *testing.T) {
+	Convey("Testing user sessions", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			admin := h.User().Search(env, q.User().Login().Equals("admin"))
+			sessions := h.UserSession().NewSet(env)
+			demoSessions := sessions.Sudo(demo.ID())
+			demoSession := sessions.OpenSession(demo, "demo-key-1", "10.0.0.1", "Firefox")
+			sessions.OpenSession(demo, "demo-key-2", "10.0.0.2", "Chrome")
+			adminSession := sessions.OpenSession(admin, "admin-key", "10.0.0.3", "Safari")
+			Convey("Sessions should be found by their key only", func() {
+				So(demoSession.KeyHash(), ShouldNotEqual, "demo-key-1")
+				So(sessions.FindSession("demo-key-1").Equals(demoSession), ShouldBeTrue)
+				So(sessions.FindSession("unknown-key").IsEmpty(), ShouldBeTrue)
+				So(sessions.FindSession("").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Users should only see their own sessions", func() {
+				So(h.UserSession().Search(env, q.UserSession().ID().Equals(adminSession.ID())).Len(), ShouldEqual, 1)
+				So(demoSessions.Search(q.UserSession().ID().Equals(adminSession.ID())).IsEmpty(), ShouldBeTrue)
+				demoVisible := demoSessions.SearchAll()
+				So(demoVisible.Len(), ShouldEqual, 2)
+				for _, sess := range demoVisible.Records() {
+					So(sess.User().Equals(demo), ShouldBeTrue)
+				}
+			})
+			Convey("Users should not revoke sessions of other users", func() {
+				So(func() { adminSession.Sudo(demo.ID()).Revoke() }, ShouldPanic)
+				So(demoSession.Revoke(), ShouldBeTrue)
+				So(sessions.FindSession("demo-key-1").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Administrators should revoke sessions of any user", func() {
+				So(demoSession.Sudo(admin.ID()).Revoke(), ShouldBeTrue)
+				So(sessions.FindSession("demo-key-1").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Sessions should expire after the idle timeout or their lifetime", func() {
+				So(demoSession.IsExpired(0, 0), ShouldBeFalse)
+				So(demoSession.IsExpired(time.Hour, 24*time.Hour), ShouldBeFalse)
+				So(demoSession.IsExpired(0, time.Nanosecond), ShouldBeTrue)
+				demoSession.Sudo().SetLastActivity(dates.Now().Add(-2 * time.Hour))
+				So(demoSession.IsExpired(time.Hour, 0), ShouldBeTrue)
+				So(demoSession.IsExpired(3*time.Hour, 0), ShouldBeFalse)
+				So(sessions.RemoveExpiredSessions(0, 0), ShouldEqual, 0)
+				So(sessions.RemoveExpiredSessions(time.Hour, 0), ShouldBeGreaterThanOrEqualTo, 1)
+				So(sessions.FindSession("demo-key-1").IsEmpty(), ShouldBeTrue)
+				So(sessions.FindSession("demo-key-2").IsEmpty(), ShouldBeFalse)
+			})
+			Convey("Session management methods should not be callable by users", func() {
+				So(func() { demoSessions.OpenSession(demo, "demo-key-3", "10.0.0.4", "Firefox") }, ShouldPanic)
+				So(func() { demoSessions.FindSession("admin-key") }, ShouldPanic)
+				So(func() { demoSession.Sudo(demo.ID()).Touch("10.0.0.5") }, ShouldPanic)
+				So(func() { demoSession.Sudo(demo.ID()).IsExpired(0, 0) }, ShouldPanic)
+				So(func() { demoSessions.RemoveExpiredSessions(time.Nanosecond, time.Nanosecond) }, ShouldPanic)
+				So(sessions.FindSession("admin-key").IsEmpty(), ShouldBeFalse)
+			})
+			Convey("Logging out other devices should keep the current session only", func() {
+				So(demoSessions.RevokeOtherSessions("demo-key-2"), ShouldEqual, 1)
+				So(sessions.FindSession("demo-key-1").IsEmpty(), ShouldBeTrue)
+				So(sessions.FindSession("demo-key-2").IsEmpty(), ShouldBeFalse)
+				So(sessions.FindSession("admin-key").IsEmpty(), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
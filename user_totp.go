@@ -0,0 +1,225 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// TOTP parameters as defined by RFC 6238. These are the defaults
+// of authenticator apps, which often ignore other values.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is the number of time steps before and after
+	// the current one during which a code is accepted.
+	totpSkew = 1
+)
+
+// totpRecoveryCodesCount is the number of recovery codes generated at enrollment
+const totpRecoveryCodesCount = 10
+
+// totpEncoding is the base32 encoding of TOTP secrets
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a new random base32 encoded TOTP secret
+func newTOTPSecret() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("unable to generate TOTP secret: %s", err))
+	}
+	return totpEncoding.EncodeToString(buf)
+}
+
+// totpCode returns the code of the given base32 secret for the given time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %s", err)
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits))), nil
+}
+
+// matchTOTP returns the time step at which the given code is valid for
+// the given secret around time t, or 0 if the code is not valid.
+func matchTOTP(secret, code string, t time.Time) int64 {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return 0
+	}
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// totpURI returns the otpauth URI of the given secret to be scanned by authenticator apps
+func totpURI(issuer, account, secret string) string {
+	vals := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, vals.Encode())
+}
+
+// hashRecoveryCode returns the hash of the given recovery code,
+// ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns new random recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, totpRecoveryCodesCount)
+	hashes := make([]string, totpRecoveryCodesCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Errorf("unable to generate recovery code: %s", err))
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes
+}
+
+// ComputeTOTPEnabled returns whether this user has enrolled an authenticator app
+func user_ComputeTOTPEnabled(rs m.UserSet) m.UserData {
+	return h.User().NewData().SetTOTPEnabled(rs.Sudo().TOTPSecret() != "")
+}
+
+// checkTOTPSelf panics if rs is not the current user, since
+// users can only manage their own two-factor authentication.
+func checkTOTPSelf(rs m.UserSet) {
+	rs.EnsureOne()
+	if rs.ID() != rs.Env().Uid() {
+		log.Panic(rs.T("You can only set up two-factor authentication for yourself"), "user", rs.ID())
+	}
+}
+
+// TOTPEnroll starts the enrollment of an authenticator app for this user and
+// returns the otpauth URI of the new secret to be scanned by the app.
+//
+// Two-factor authentication is only enabled once a first code of the
+// app has been checked with TOTPConfirm.
+func user_TOTPEnroll(rs m.UserSet) string {
+	checkTOTPSelf(rs)
+	secret := newTOTPSecret()
+	rs.Sudo().SetTOTPPendingSecret(secret)
+	issuer := rs.Sudo().Company().Name()
+	if issuer == "" {
+		issuer = "Hexya"
+	}
+	return totpURI(issuer, rs.Sudo().Login(), secret)
+}
+
+// TOTPConfirm enables two-factor authentication for this user if the given code
+// matches the secret being enrolled. It returns the recovery codes that can be
+// used once each instead of a code if the authenticator app is lost.
+func user_TOTPConfirm(rs m.UserSet, code string) []string {
+	checkTOTPSelf(rs)
+	secret := rs.Sudo().TOTPPendingSecret()
+	if secret == "" {
+		log.Panic(rs.T("No two-factor authentication enrollment in progress"), "user", rs.ID())
+	}
+	step := matchTOTP(secret, code, time.Now())
+	if step == 0 {
+		log.Panic(rs.T("Invalid authentication code"), "user", rs.ID())
+	}
+	codes, hashes := newRecoveryCodes()
+	rs.Sudo().Write(h.User().NewData().
+		SetTOTPSecret(secret).
+		SetTOTPPendingSecret("").
+		SetTOTPLastStep(step).
+		SetTOTPRecoveryCodes(strings.Join(hashes, "\n")))
+	return codes
+}
+
+// TOTPVerify returns true if the given code is a valid code of the authenticator
+// app of this user or one of their unused recovery codes.
+//
+// Codes are accepted only once: used recovery codes are deleted and a TOTP code
+// is refused if a code of the same or a later time step has already been used.
+func user_TOTPVerify(rs m.UserSet, code string) bool {
+	rs.EnsureOne()
+	user := rs.Sudo()
+	secret := user.TOTPSecret()
+	if secret == "" {
+		return false
+	}
+	if step := matchTOTP(secret, code, time.Now()); step > 0 {
+		if step <= user.TOTPLastStep() {
+			return false
+		}
+		user.SetTOTPLastStep(step)
+		return true
+	}
+	hash := hashRecoveryCode(code)
+	hashes := strings.Split(user.TOTPRecoveryCodes(), "\n")
+	for i, hashed := range hashes {
+		if hashed == "" || subtle.ConstantTimeCompare([]byte(hashed), []byte(hash)) != 1 {
+			continue
+		}
+		user.SetTOTPRecoveryCodes(strings.Join(append(hashes[:i], hashes[i+1:]...), "\n"))
+		log.Info("recovery code used", "user", rs.ID(), "remaining", len(hashes)-1)
+		return true
+	}
+	return false
+}
+
+// TOTPReset disables two-factor authentication for the users of this RecordSet,
+// for instance when they have lost both their authenticator app and their
+// recovery codes. They can then enroll a new app.
+func user_TOTPReset(rs m.UserSet) bool {
+	rs.Sudo().Write(h.User().NewData().
+		SetTOTPSecret("").
+		SetTOTPPendingSecret("").
+		SetTOTPLastStep(0).
+		SetTOTPRecoveryCodes(""))
+	return true
+}
+
+func init() {
+	h.User().NewMethod("ComputeTOTPEnabled", user_ComputeTOTPEnabled)
+	h.User().NewMethod("TOTPEnroll", user_TOTPEnroll)
+	h.User().NewMethod("TOTPConfirm", user_TOTPConfirm)
+	h.User().NewMethod("TOTPVerify", user_TOTPVerify)
+	h.User().NewMethod("TOTPReset", user_TOTPReset)
+}
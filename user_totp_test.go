@@ -0,0 +1,93 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTOTPCode(t *testing.T) {
+	Convey("Testing TOTP codes with RFC 6238 test vectors", t, func() {
+		secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+		for ts, code := range map[int64]string{
+			59:          "287082",
+			1111111109:  "081804",
+			1234567890:  "005924",
+			20000000000: "353130",
+		} {
+			res, err := totpCode(secret, ts/totpPeriod)
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, code)
+		}
+		So(matchTOTP(secret, "287082", time.Unix(59, 0)), ShouldEqual, 1)
+		So(matchTOTP(secret, "287082", time.Unix(89, 0)), ShouldEqual, 1)
+		So(matchTOTP(secret, "287082", time.Unix(150, 0)), ShouldEqual, 0)
+		So(matchTOTP(secret, "28708", time.Unix(59, 0)), ShouldEqual, 0)
+	})
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	Convey("Testing TOTP enrollment and verification", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			admin := h.User().Search(env, q.User().Login().Equals("admin"))
+			demoUser := demo.Sudo(demo.ID())
+			uri, err := url.Parse(demoUser.TOTPEnroll())
+			So(err, ShouldBeNil)
+			So(uri.Scheme, ShouldEqual, "otpauth")
+			secret := uri.Query().Get("secret")
+			So(secret, ShouldNotBeBlank)
+			So(demo.TOTPEnabled(), ShouldBeFalse)
+			Convey("Users should not enroll for other users", func() {
+				So(func() { admin.Sudo(demo.ID()).TOTPEnroll() }, ShouldPanic)
+			})
+			Convey("Enrollment should fail with a wrong code", func() {
+				So(func() { demoUser.TOTPConfirm("000000x") }, ShouldPanic)
+				So(demo.TOTPEnabled(), ShouldBeFalse)
+			})
+			Convey("Confirming enrollment should enable two-factor authentication", func() {
+				code, _ := totpCode(secret, time.Now().Unix()/totpPeriod)
+				recoveryCodes := demoUser.TOTPConfirm(code)
+				So(recoveryCodes, ShouldHaveLength, totpRecoveryCodesCount)
+				So(demo.TOTPEnabled(), ShouldBeTrue)
+				Convey("Codes should not be accepted twice", func() {
+					So(demo.TOTPVerify(code), ShouldBeFalse)
+					next, _ := totpCode(secret, time.Now().Unix()/totpPeriod+1)
+					So(demo.TOTPVerify(next), ShouldBeTrue)
+					So(demo.TOTPVerify(next), ShouldBeFalse)
+				})
+				Convey("Recovery codes should be accepted once", func() {
+					So(demo.TOTPVerify(recoveryCodes[0]), ShouldBeTrue)
+					So(demo.TOTPVerify(recoveryCodes[0]), ShouldBeFalse)
+					So(demo.TOTPVerify(recoveryCodes[1]), ShouldBeTrue)
+				})
+				Convey("Secrets should never be read, searched or exported by users", func() {
+					So(demo.TOTPSecret(), ShouldEqual, secret)
+					res := demoUser.Read(models.FieldNames{h.User().Fields().Name(), h.User().Fields().TOTPSecret()})
+					So(res[0].Underlying().Has(h.User().Fields().TOTPSecret()), ShouldBeFalse)
+					So(func() { demoUser.Search(q.User().TOTPSecret().Equals(secret)) }, ShouldPanic)
+					So(demoUser.FieldsGet(models.FieldsGetArgs{}), ShouldNotContainKey, "totp_secret")
+					So(demo.FieldsGet(models.FieldsGetArgs{}), ShouldContainKey, "totp_secret")
+					So(func() { demoUser.ExportData([]string{"login", "totp_secret"}) }, ShouldPanic)
+					So(func() { demoUser.ExportData([]string{"login", "password"}) }, ShouldPanic)
+					So(func() { demoUser.ExportData([]string{"login"}) }, ShouldNotPanic)
+				})
+				Convey("Administrators should reset two-factor authentication", func() {
+					So(func() { demo.Sudo(demo.ID()).TOTPReset() }, ShouldPanic)
+					So(demo.Sudo(admin.ID()).TOTPReset(), ShouldBeTrue)
+					So(demo.TOTPEnabled(), ShouldBeFalse)
+					So(demo.TOTPVerify(recoveryCodes[2]), ShouldBeFalse)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
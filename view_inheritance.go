@@ -0,0 +1,149 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/types"
+	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/hexya/src/views"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// viewDefinitions holds the view definitions read from the resource files
+// of all modules, by ID and by inherited view ID for anonymous extensions.
+var viewDefinitions struct {
+	sync.Once
+	byID   map[string]*webtypes.InheritedView
+	byBase map[string][]*webtypes.InheritedView
+}
+
+// loadViewDefinitions scans the XML resource files of all modules for
+// view definitions, in the same order as the server loads them.
+//
+// View inheritance is resolved by the views package at bootstrap and the
+// views registry does not keep track of the extensions that were applied,
+// so we need to read them again from the resource files.
+func loadViewDefinitions() {
+	viewDefinitions.byID = make(map[string]*webtypes.InheritedView)
+	viewDefinitions.byBase = make(map[string][]*webtypes.InheritedView)
+	for _, mod := range server.Modules {
+		files, err := filepath.Glob(filepath.Join(server.ResourceDir, "resources", mod.Name, "*.xml"))
+		if err != nil {
+			log.Warn("Unable to scan resource directory", "module", mod.Name, "error", err)
+			continue
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			doc := etree.NewDocument()
+			if err := doc.ReadFromFile(file); err != nil {
+				log.Warn("Unable to read resource file", "file", file, "error", err)
+				continue
+			}
+			for _, viewTag := range doc.FindElements("hexya/data/view") {
+				def := &webtypes.InheritedView{
+					XMLID:     viewTag.SelectAttrValue("id", ""),
+					InheritID: viewTag.SelectAttrValue("inherit_id", ""),
+					Module:    mod.Name,
+					File:      filepath.Base(file),
+				}
+				if def.XMLID != "" {
+					viewDefinitions.byID[def.XMLID] = def
+				}
+				if def.InheritID != "" && def.XMLID == "" {
+					viewDefinitions.byBase[def.InheritID] = append(viewDefinitions.byBase[def.InheritID], def)
+				}
+			}
+		}
+	}
+}
+
+// inheritedViews returns the view definitions that contribute to the arch of the
+// view with the given ID, starting with the base view and followed by the
+// extensions in the order they are applied.
+//
+// Views that have not been defined in a resource file (e.g. default views) are
+// returned with only their XMLID set.
+func inheritedViews(viewID string) []webtypes.InheritedView {
+	viewDefinitions.Do(loadViewDefinitions)
+	var res []webtypes.InheritedView
+	def, ok := viewDefinitions.byID[viewID]
+	switch {
+	case !ok:
+		res = append(res, webtypes.InheritedView{XMLID: viewID})
+	case def.InheritID != "":
+		// This is a named extension, which is created from its base view
+		res = append(inheritedViews(def.InheritID), *def)
+	default:
+		res = append(res, *def)
+	}
+	for _, ext := range viewDefinitions.byBase[viewID] {
+		res = append(res, *ext)
+	}
+	return res
+}
+
+// GetViewArch returns the arch of the view with the given ID as it is stored
+// in the views registry, i.e. with all inheritance applied but before any
+// processing for the current user. The view must belong to this model.
+//
+// The view definitions that contributed to this arch are also returned
+// to help diagnose inheritance problems.
+//
+// Since this arch includes the nodes that are removed for the groups of the
+// user, only technical users may get it.
+func commonMixin_GetViewArch(rs m.CommonMixinSet, viewID string) *webtypes.ViewArchData {
+	if !canSeeRawArch(rs.Env()) {
+		log.Panic(rs.T("Only technical users can see the arch of views"))
+	}
+	view := views.Registry.GetByID(viewID)
+	if view == nil {
+		log.Panic("Unknown view", "view", viewID)
+	}
+	if view.Model != rs.ModelName() {
+		log.Panic("View does not belong to this model", "view", viewID, "model", rs.ModelName(), "viewModel", view.Model)
+	}
+	arch, err := view.Arch(rs.Env().Context().GetString("lang")).WriteToString()
+	if err != nil {
+		log.Panic("Unable to write view arch", "view", viewID, "error", err)
+	}
+	return &webtypes.ViewArchData{
+		ID:             view.ID,
+		Name:           view.Name,
+		Model:          view.Model,
+		Type:           view.Type,
+		Arch:           arch,
+		InheritedViews: inheritedViews(view.ID),
+	}
+}
+
+// canSeeRawArch returns true if the current user of env may see the arch of views
+// before it is processed for their groups, i.e. if they are a technical user.
+func canSeeRawArch(env models.Environment) bool {
+	user := h.User().NewSet(env).CurrentUser().Sudo()
+	return user.IsSuperUser() || user.IsSystem()
+}
+
+// debugMode returns true if the given context has the 'debug' key set,
+// either as a boolean or as a non empty debug mode string.
+func debugMode(ctx *types.Context) bool {
+	switch v := ctx.Get("debug").(type) {
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "0"
+	}
+	return false
+}
+
+func init() {
+	h.CommonMixin().NewMethod("GetViewArch", commonMixin_GetViewArch)
+}
@@ -0,0 +1,354 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package web
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
+	"github.com/hexya-erp/hexya/src/tools/nbutils"
+	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+	"github.com/hexya-erp/hexya/src/views"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/m"
+)
+
+// CheckViewModifiers panics if the given data does not comply with the readonly
+// and required rules of the form view of the model. The data is checked for writing
+// on the records of this RecordSet, or for creating a new record if it is empty.
+//
+// Rules are taken from the modifiers of the view, which include the field-level
+// rules. Fields that are not in the view processed for the current user, such as
+// fields restricted to other groups, cannot be written. The view is always the
+// default form view of the model, whatever the view the client claims to use.
+//
+// Modifiers given as domains are evaluated on the current values of the records
+// when writing, and on the given data when creating. Readonly fields with the
+// 'force_save' attribute in the view can be written.
+//
+// Create and update commands of x2many fields are checked in the same way
+// against the view embedded in the field tag or, if there is none, against
+// the default form view of the related model.
+func commonMixin_CheckViewModifiers(rs m.CommonMixinSet, data models.RecordData) {
+	view := views.Registry.GetFirstViewForModel(rs.ModelName(), views.ViewTypeForm)
+	checkViewModifiers(rs.Collection(), view, data, "")
+}
+
+// checkViewModifiers panics if the given data does not comply with the modifiers
+// of the given view for the records of rc.
+//
+// parentField is the JSON name of the field that points to the parent record
+// when checking x2many lines. It is set by the server and is never required.
+func checkViewModifiers(rc *models.RecordCollection, view *views.View, data models.RecordData, parentField string) {
+	lang := rc.Env().Context().GetString("lang")
+	cols := make([]models.FieldName, len(view.Fields))
+	for i, f := range view.Fields {
+		cols[i] = rc.Model().FieldName(f)
+	}
+	fInfos := rc.Call("FieldsGet", models.FieldsGetArgs{Fields: cols}).(map[string]*models.FieldInfo)
+	viewFields := modifiedFieldElements(rc.Call("ProcessView", view.Arch(lang), fInfos).(string))
+	values := make(map[string]interface{})
+	for _, fName := range data.Underlying().FieldNames() {
+		values[fName.JSON()] = data.Underlying().Get(fName)
+	}
+
+	for _, fName := range data.Underlying().FieldNames() {
+		fieldTag, inView := viewFields[fName.JSON()]
+		if !inView {
+			log.Panic(rc.T("Field '%s' cannot be written from this view", fName.JSON()), "model", rc.ModelName(), "view", view.ID)
+		}
+		modifiers := elementModifiers(fieldTag)
+		if fieldTag.SelectAttrValue("force_save", "") == "" && modifierApplies(rc, modifiers["readonly"], values) {
+			log.Panic(rc.T("Field '%s' is read only", fName.JSON()), "model", rc.ModelName(), "view", view.ID)
+		}
+		fInfo := fInfos[fName.JSON()]
+		if isEmptyClientValue(values[fName.JSON()], fInfo.Type) && modifierApplies(rc, modifiers["required"], values) {
+			log.Panic(rc.T("Field '%s' is required", fName.JSON()), "model", rc.ModelName(), "view", view.ID)
+		}
+		if fInfo.Type.Is2ManyRelationType() {
+			checkLineCommands(rc, view, fName, fInfo, values[fName.JSON()])
+		}
+	}
+	if rc.IsNotEmpty() {
+		return
+	}
+	// On creation, all required fields of the view must be given
+	for fName, fieldTag := range viewFields {
+		if _, given := values[fName]; given || fName == parentField {
+			continue
+		}
+		if fInfo, ok := fInfos[fName]; ok && fInfo.Type == fieldtype.Boolean {
+			continue
+		}
+		if modifierApplies(rc, elementModifiers(fieldTag)["required"], values) {
+			log.Panic(rc.T("Field '%s' is required", fName), "model", rc.ModelName(), "view", view.ID)
+		}
+	}
+}
+
+// checkLineCommands checks the create and update commands of the given x2many
+// field value against the modifiers of the view used to edit its lines.
+func checkLineCommands(rc *models.RecordCollection, view *views.View, fName models.FieldName, fInfo *models.FieldInfo, value interface{}) {
+	commands, ok := value.([]interface{})
+	if !ok {
+		return
+	}
+	relRC := rc.Env().Pool(fInfo.Relation)
+	lineView := lineSubView(view, fName, fInfo.Relation)
+	var parentField string
+	if fInfo.ReverseFK != "" {
+		parentField = relRC.Model().FieldName(fInfo.ReverseFK).JSON()
+	}
+	for _, command := range commands {
+		cmd, ok := command.([]interface{})
+		if !ok || len(cmd) < 3 {
+			continue
+		}
+		var vals map[string]interface{}
+		switch v := cmd[2].(type) {
+		case map[string]interface{}:
+			vals = v
+		case models.FieldMap:
+			vals = v
+		default:
+			continue
+		}
+		action, err := nbutils.CastToInteger(cmd[0])
+		if err != nil {
+			log.Panic("Unable to read x2many command", "error", err, "model", rc.ModelName(), "field", fName, "command", cmd)
+		}
+		switch action {
+		case 0:
+			checkViewModifiers(relRC, lineView, models.NewModelData(relRC.Model(), vals), parentField)
+		case 1:
+			id, err := nbutils.CastToInteger(cmd[1])
+			if err != nil {
+				log.Panic("Unable to read x2many command", "error", err, "model", rc.ModelName(), "field", fName, "command", cmd)
+			}
+			line := relRC.Search(relRC.Model().Field(models.ID).Equals(id))
+			checkViewModifiers(line, lineView, models.NewModelData(relRC.Model(), vals), parentField)
+		}
+	}
+}
+
+// lineSubView returns the view used to edit the lines of the given x2many field
+// of view. This is the form view embedded in the field tag, then the embedded
+// tree view (for editable lists) and the default form view of the relation
+// model if there is no embedded view.
+func lineSubView(view *views.View, fName models.FieldName, relation string) *views.View {
+	sViews, ok := view.SubViews[fName.Name()]
+	if !ok {
+		sViews = view.SubViews[fName.JSON()]
+	}
+	for _, vType := range []views.ViewType{views.ViewTypeForm, views.ViewTypeTree} {
+		if sv, exists := sViews[vType]; exists {
+			return sv
+		}
+	}
+	return views.Registry.GetFirstViewForModel(relation, views.ViewTypeForm)
+}
+
+// modifiedFieldElements parses the given processed view arch and returns the
+// first field element of each field, by field name.
+func modifiedFieldElements(arch string) map[string]*etree.Element {
+	doc, err := xmlutils.XMLToDocument(arch)
+	if err != nil {
+		log.Panic("Unable to read processed view", "error", err, "arch", arch)
+	}
+	res := make(map[string]*etree.Element)
+	for _, fieldTag := range doc.FindElements("//field") {
+		fName := fieldTag.SelectAttrValue("name", "")
+		if _, exists := res[fName]; !exists {
+			res[fName] = fieldTag
+		}
+	}
+	return res
+}
+
+// elementModifiers returns the modifiers map set on the given element by AddModifiers
+func elementModifiers(element *etree.Element) map[string]interface{} {
+	modifiers := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(element.SelectAttrValue("modifiers", "{}")), &modifiers); err != nil {
+		log.Panic("Unable to read modifiers", "error", err, "modifiers", element.SelectAttrValue("modifiers", ""))
+	}
+	return modifiers
+}
+
+// modifierApplies returns true if the given modifier value applies to
+// the records of the given RecordCollection.
+//
+// Domain modifiers apply if one of the records match the domain or, if the
+// RecordCollection is empty, if the given values of a new record match the domain.
+// They never apply when they reference fields of a parent record.
+func modifierApplies(rc *models.RecordCollection, modifier interface{}, values map[string]interface{}) bool {
+	var dom domains.Domain
+	switch mod := modifier.(type) {
+	case nil:
+		return false
+	case bool:
+		return mod
+	case string:
+		dom = *domains.ParseString(mod)
+	case []interface{}:
+		dom = domains.Domain(mod)
+	default:
+		log.Panic("Unexpected modifier value", "modifier", modifier)
+	}
+	if len(dom) == 0 {
+		return false
+	}
+	for _, term := range dom {
+		if leaf, ok := term.([]interface{}); ok && len(leaf) > 0 {
+			if fName, _ := leaf[0].(string); strings.HasPrefix(fName, "parent.") {
+				return false
+			}
+		}
+	}
+	if rc.IsEmpty() {
+		return domainMatchesValues(dom, values)
+	}
+	cond := rc.Model().Field(models.ID).In(rc.Ids()).AndCond(domains.ParseDomain(dom, rc.Model()))
+	return rc.Search(cond).Len() > 0
+}
+
+// domainMatchesValues returns true if the given values, by field JSON name, match
+// the given domain. Fields missing from values are considered empty.
+//
+// Leaves with an unsupported operator are considered as matching, so that the
+// modifiers using them are enforced.
+func domainMatchesValues(dom domains.Domain, values map[string]interface{}) bool {
+	var stack []bool
+	pop := func() bool {
+		if len(stack) == 0 {
+			return true
+		}
+		res := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return res
+	}
+	// Terms are in prefix notation, so we evaluate them from the end
+	for i := len(dom) - 1; i >= 0; i-- {
+		switch term := dom[i].(type) {
+		case string:
+			switch term {
+			case "!":
+				stack = append(stack, !pop())
+			case "|":
+				left, right := pop(), pop()
+				stack = append(stack, left || right)
+			default:
+				left, right := pop(), pop()
+				stack = append(stack, left && right)
+			}
+		case []interface{}:
+			stack = append(stack, leafMatchesValues(term, values))
+		}
+	}
+	for _, res := range stack {
+		if !res {
+			return false
+		}
+	}
+	return true
+}
+
+// leafMatchesValues returns true if the given values match the given domain leaf
+func leafMatchesValues(leaf []interface{}, values map[string]interface{}) bool {
+	if len(leaf) != 3 {
+		return true
+	}
+	fName, _ := leaf[0].(string)
+	op, _ := leaf[1].(string)
+	value, target := domainValue(values[fName]), domainValue(leaf[2])
+	switch op {
+	case "=", "==":
+		return reflect.DeepEqual(value, target)
+	case "!=", "<>":
+		return !reflect.DeepEqual(value, target)
+	case "in", "not in":
+		var found bool
+		if list, ok := leaf[2].([]interface{}); ok {
+			for _, item := range list {
+				if reflect.DeepEqual(value, domainValue(item)) {
+					found = true
+					break
+				}
+			}
+		}
+		return found == (op == "in")
+	case "<", "<=", ">", ">=":
+		v, vOK := value.(float64)
+		t, tOK := target.(float64)
+		if !vOK || !tOK {
+			return true
+		}
+		switch op {
+		case "<":
+			return v < t
+		case "<=":
+			return v <= t
+		case ">":
+			return v > t
+		default:
+			return v >= t
+		}
+	}
+	return true
+}
+
+// domainValue returns the given client or domain value in a normalized form
+// for comparison: numbers and record IDs as float64 and empty values as false.
+func domainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		if v == "" {
+			return false
+		}
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case models.RecordSet:
+		if v.IsEmpty() {
+			return false
+		}
+		return float64(v.Ids()[0])
+	}
+	return value
+}
+
+// isEmptyClientValue returns true if the given value received from the client
+// is considered as empty for a required field of the given type.
+//
+// Boolean fields are never empty, since false is a valid value. For other
+// fields, false is sent by the client for empty values.
+func isEmptyClientValue(value interface{}, fType fieldtype.Type) bool {
+	if fType == fieldtype.Boolean {
+		return false
+	}
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case models.RecordSet:
+		return v.IsEmpty()
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func init() {
+	h.CommonMixin().NewMethod("CheckViewModifiers", commonMixin_CheckViewModifiers)
+}
@@ -4,12 +4,21 @@
 package web
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/tools/xmlutils"
+	"github.com/hexya-erp/hexya/src/views"
 	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -45,6 +54,136 @@ var viewFieldInfos2 = map[string]*models.FieldInfo{
 	"tz":   {ReadOnly: true},
 }
 
+var viewDef3 = `
+<form>
+	<header>
+		<button name="action_confirm" string="Confirm" groups="admin"/>
+	</header>
+	<notebook>
+		<page string="Main">
+			<field name="name"/>
+			<field name="login" groups="everyone,admin"/>
+		</page>
+		<page string="Technical" groups="admin">
+			<field name="tz"/>
+			<field name="name"/>
+		</page>
+	</notebook>
+</form>
+`
+
+var viewFieldInfos3 = map[string]*models.FieldInfo{
+	"name":  {},
+	"login": {},
+	"tz":    {},
+}
+
+func TestViewGroups(t *testing.T) {
+	Convey("Testing groups management in views", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			demoUser := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+			Convey("Nodes should be kept for users belonging to the groups", func() {
+				fInfos := make(map[string]*models.FieldInfo)
+				for k, v := range viewFieldInfos3 {
+					fInfos[k] = v
+				}
+				v, _ := xmlutils.XMLToDocument(viewDef3)
+				view := h.User().NewSet(env).ProcessView(v, fInfos)
+				So(view, ShouldContainSubstring, `<button name="action_confirm"`)
+				So(view, ShouldContainSubstring, `<page string="Technical"`)
+				So(view, ShouldContainSubstring, `<field name="login"`)
+				So(view, ShouldNotContainSubstring, "groups=")
+				So(fInfos, ShouldHaveLength, 3)
+			})
+			Convey("Nodes should be removed for users not belonging to the groups", func() {
+				fInfos := make(map[string]*models.FieldInfo)
+				for k, v := range viewFieldInfos3 {
+					fInfos[k] = v
+				}
+				v, _ := xmlutils.XMLToDocument(viewDef3)
+				view := h.User().NewSet(env).Sudo(demoUser.ID()).ProcessView(v, fInfos)
+				So(view, ShouldNotContainSubstring, `action_confirm`)
+				So(view, ShouldNotContainSubstring, `Technical`)
+				So(view, ShouldNotContainSubstring, `<field name="tz"`)
+				So(view, ShouldContainSubstring, `<field name="name"`)
+				So(view, ShouldContainSubstring, `<field name="login"`)
+				So(view, ShouldContainSubstring, `<header>`)
+				So(fInfos, ShouldContainKey, "name")
+				So(fInfos, ShouldContainKey, "login")
+				So(fInfos, ShouldNotContainKey, "tz")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+var viewDef4 = `
+<tree>
+	<field name="name" invisible="1"/>
+	<field name="login" attrs="{'column_invisible': [('parent.active', '=', False)], 'readonly': [('parent.active', '=', False)]}"/>
+	<field name="tz" invisible="context.get('hide_tz')" column_invisible="not context.get('show_tz', True)"/>
+	<field name="email" attrs="{'invisible': [('lang', '=', context.get('lang'))]}" invisible="0"/>
+</tree>
+`
+
+var viewFieldInfos4 = map[string]*models.FieldInfo{
+	"name":  {},
+	"login": {},
+	"tz":    {},
+	"email": {},
+}
+
+// getViewModifiers returns the modifiers of the field with the given name in the given view
+func getViewModifiers(view, fieldName string) map[string]interface{} {
+	doc, err := xmlutils.XMLToDocument(view)
+	So(err, ShouldBeNil)
+	fieldTag := doc.FindElement(fmt.Sprintf("//field[@name='%s']", fieldName))
+	So(fieldTag, ShouldNotBeNil)
+	var res map[string]interface{}
+	So(json.Unmarshal([]byte(fieldTag.SelectAttrValue("modifiers", "")), &res), ShouldBeNil)
+	return res
+}
+
+func TestListViewModifiers(t *testing.T) {
+	Convey("Testing modifiers in list views", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("invisible fields in list views should hide the column", func() {
+				v, _ := xmlutils.XMLToDocument(viewDef4)
+				view := h.User().NewSet(env).ProcessView(v, viewFieldInfos4)
+				mods := getViewModifiers(view, "name")
+				So(mods, ShouldContainKey, "invisible")
+				So(mods["tree_invisible"], ShouldEqual, true)
+				So(mods["column_invisible"], ShouldEqual, true)
+			})
+			Convey("parent prefix should be removed from column_invisible domains only", func() {
+				v, _ := xmlutils.XMLToDocument(viewDef4)
+				view := h.User().NewSet(env).ProcessView(v, viewFieldInfos4)
+				mods := getViewModifiers(view, "login")
+				So(mods["column_invisible"], ShouldResemble, []interface{}{[]interface{}{"active", "=", false}})
+				So(mods["readonly"], ShouldResemble, []interface{}{[]interface{}{"parent.active", "=", false}})
+			})
+			Convey("Context expressions should be resolved", func() {
+				v, _ := xmlutils.XMLToDocument(viewDef4)
+				view := h.User().NewSet(env).WithContext("lang", "fr_FR").ProcessView(v, viewFieldInfos4)
+				So(getViewModifiers(view, "tz"), ShouldBeEmpty)
+				So(getViewModifiers(view, "email")["invisible"], ShouldResemble, []interface{}{[]interface{}{"lang", "=", "fr_FR"}})
+				view = h.User().NewSet(env).WithContext("hide_tz", true).ProcessView(v, viewFieldInfos4)
+				So(getViewModifiers(view, "tz")["invisible"], ShouldEqual, true)
+				view = h.User().NewSet(env).WithContext("show_tz", false).ProcessView(v, viewFieldInfos4)
+				mods := getViewModifiers(view, "tz")
+				So(mods, ShouldNotContainKey, "invisible")
+				So(mods["column_invisible"], ShouldEqual, true)
+			})
+			Convey("column_invisible should be removed outside list views", func() {
+				v, _ := xmlutils.XMLToDocument(strings.Replace(viewDef4, "tree>", "form>", -1))
+				view := h.User().NewSet(env).ProcessView(v, viewFieldInfos4)
+				So(getViewModifiers(view, "name"), ShouldNotContainKey, "column_invisible")
+				So(getViewModifiers(view, "name"), ShouldNotContainKey, "tree_invisible")
+				So(getViewModifiers(view, "login"), ShouldNotContainKey, "column_invisible")
+			})
+		}), ShouldBeNil)
+	})
+}
+
 func TestViewModifiers(t *testing.T) {
 	Convey("Testing correct modifiers injection in views", t, func() {
 		models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
@@ -93,3 +232,147 @@ func TestViewModifiers(t *testing.T) {
 		})
 	})
 }
+
+func TestToolbar(t *testing.T) {
+	Convey("Testing toolbar actions", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			companyAction := actions.Registry.MustGetByXMLID("base_company_normal_action_tree")
+			Convey("Window actions should be set in the relate section", func() {
+				for _, vType := range []views.ViewType{views.ViewTypeTree, views.ViewTypeForm} {
+					toolbar := h.Company().NewSet(env).GetToolbar(vType)
+					So(toolbar.Relate, ShouldContain, companyAction)
+					So(toolbar.Action, ShouldBeEmpty)
+					So(toolbar.Print, ShouldBeEmpty)
+				}
+			})
+			Convey("Server actions should be set in the action section", func() {
+				toolbar := h.Group().NewSet(env).GetToolbar(views.ViewTypeTree)
+				So(toolbar.Action, ShouldContain, actions.Registry.MustGetByXMLID("base_action_server_reload_groups"))
+				So(toolbar.Relate, ShouldBeEmpty)
+			})
+			Convey("Views without sidebar should have no toolbar", func() {
+				toolbar := h.Company().NewSet(env).GetToolbar(views.ViewTypeKanban)
+				So(toolbar.Relate, ShouldBeEmpty)
+			})
+			Convey("Multi actions should only be bound to list views", func() {
+				defer func(multi bool) { companyAction.Multi = multi }(companyAction.Multi)
+				companyAction.Multi = true
+				So(h.Company().NewSet(env).GetToolbar(views.ViewTypeTree).Relate, ShouldContain, companyAction)
+				So(h.Company().NewSet(env).GetToolbar(views.ViewTypeForm).Relate, ShouldNotContain, companyAction)
+			})
+			Convey("Actions should be filtered by the user's groups", func() {
+				pwdAction := actions.Registry.MustGetByXMLID("base_change_password_wizard_action")
+				demoUser := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+				toolbar := h.User().NewSet(env).Sudo(demoUser.ID()).GetToolbar(views.ViewTypeForm)
+				So(toolbar.Relate, ShouldNotContain, pwdAction)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestViewDebug(t *testing.T) {
+	Convey("Testing debug information of views", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			params := webtypes.FieldsViewGetParams{
+				ViewID:   "base_view_users_form_simple_modif",
+				ViewType: "form",
+			}
+			Convey("Raw arch and inherited views should not be returned outside debug mode", func() {
+				res := h.User().NewSet(env).FieldsViewGet(params)
+				So(res.RawArch, ShouldBeEmpty)
+				So(res.InheritedViews, ShouldBeEmpty)
+			})
+			Convey("Raw arch and inherited views should be returned in debug mode", func() {
+				res := h.User().NewSet(env).WithContext("debug", "1").FieldsViewGet(params)
+				So(res.RawArch, ShouldContainSubstring, `<field name="chatter_position"`)
+				So(res.RawArch, ShouldNotContainSubstring, "modifiers")
+				So(res.Arch, ShouldContainSubstring, "modifiers")
+				So(res.InheritedViews, ShouldHaveLength, 2)
+				So(res.InheritedViews[0].XMLID, ShouldEqual, "base_view_users_form_simple_modif")
+				So(res.InheritedViews[0].Module, ShouldEqual, "base")
+				So(res.InheritedViews[1].XMLID, ShouldBeEmpty)
+				So(res.InheritedViews[1].InheritID, ShouldEqual, "base_view_users_form_simple_modif")
+				So(res.InheritedViews[1].Module, ShouldEqual, "web")
+				So(res.InheritedViews[1].File, ShouldEqual, "user.xml")
+			})
+			Convey("Raw arch should only be returned to technical users", func() {
+				demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+				res := h.User().NewSet(env).Sudo(demo.ID()).WithContext("debug", "1").FieldsViewGet(params)
+				So(res.RawArch, ShouldBeEmpty)
+				So(res.InheritedViews, ShouldBeEmpty)
+				So(func() { h.User().NewSet(env).Sudo(demo.ID()).GetViewArch("base_view_users_form_simple_modif") }, ShouldPanic)
+			})
+			Convey("GetViewArch should return the arch of the given view", func() {
+				res := h.User().NewSet(env).GetViewArch("base_view_users_form_simple_modif")
+				So(res.Model, ShouldEqual, "User")
+				So(res.Type, ShouldEqual, views.ViewTypeForm)
+				So(res.Arch, ShouldContainSubstring, `<field name="sidebar_visible"`)
+				So(res.InheritedViews, ShouldHaveLength, 2)
+				So(func() { h.Company().NewSet(env).GetViewArch("base_view_users_form_simple_modif") }, ShouldPanic)
+				So(func() { h.User().NewSet(env).GetViewArch("unknown_view") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestCheckViewModifiers(t *testing.T) {
+	Convey("Testing server-side check of view modifiers", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			admin := h.User().BrowseOne(env, security.SuperUserID)
+			Convey("Writable fields of the view can be written", func() {
+				So(func() { admin.CheckViewModifiers(h.User().NewData().SetEmail("admin@example.com")) }, ShouldNotPanic)
+				So(func() { admin.CheckViewModifiers(h.User().NewData().SetLogin("admin")) }, ShouldNotPanic)
+			})
+			Convey("Readonly fields of the view cannot be written", func() {
+				So(func() { admin.CheckViewModifiers(h.User().NewData().SetPartner(admin.Partner())) }, ShouldPanic)
+			})
+			Convey("The view given by the client is not used", func() {
+				simpleModif := admin.WithContext("form_view_ref", "base_view_users_form_simple_modif")
+				So(func() { simpleModif.CheckViewModifiers(h.User().NewData().SetPartner(admin.Partner())) }, ShouldPanic)
+				So(func() { simpleModif.CheckViewModifiers(h.User().NewData().SetChatterPosition("normal")) }, ShouldPanic)
+			})
+			Convey("Fields outside the processed view cannot be written", func() {
+				So(func() { admin.CheckViewModifiers(h.User().NewData().SetChatterPosition("normal")) }, ShouldPanic)
+				demo := h.User().Search(env, q.User().HexyaExternalID().Equals("base_user_demo"))
+				demoUser := demo.Sudo(demo.ID())
+				So(func() { demoUser.CheckViewModifiers(h.User().NewData().SetEmail("demo@example.com")) }, ShouldNotPanic)
+				So(func() { demoUser.CheckViewModifiers(h.User().NewData().SetActionID(actions.ActionRef{})) }, ShouldPanic)
+			})
+			Convey("Required fields cannot be left empty", func() {
+				So(func() { admin.CheckViewModifiers(h.User().NewData().SetName("")) }, ShouldPanic)
+				newUser := h.User().NewSet(env)
+				So(func() { newUser.CheckViewModifiers(h.User().NewData().SetLogin("john")) }, ShouldPanic)
+				So(func() { newUser.CheckViewModifiers(h.User().NewData().SetLogin("john").SetName("")) }, ShouldPanic)
+			})
+			Convey("Lines of x2many fields are checked against the view of the relation", func() {
+				groupLines := func(vals map[string]interface{}) models.RecordData {
+					return models.NewModelData(admin.Collection().Model(), models.FieldMap{
+						"group_ids": []interface{}{[]interface{}{float64(0), float64(0), vals}},
+					})
+				}
+				So(func() { admin.CheckViewModifiers(groupLines(map[string]interface{}{"name": "Testers"})) }, ShouldNotPanic)
+				So(func() { admin.CheckViewModifiers(groupLines(map[string]interface{}{"name": ""})) }, ShouldPanic)
+			})
+			Convey("False is not an empty value for boolean fields", func() {
+				So(isEmptyClientValue(false, fieldtype.Boolean), ShouldBeFalse)
+				So(isEmptyClientValue(false, fieldtype.Many2One), ShouldBeTrue)
+				So(isEmptyClientValue([]interface{}{}, fieldtype.Many2Many), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestDomainMatchesValues(t *testing.T) {
+	Convey("Testing the evaluation of modifier domains on the values of new records", t, func() {
+		values := map[string]interface{}{"state": "draft", "amount": float64(10), "partner_id": int64(3)}
+		So(domainMatchesValues(domains.Domain{[]interface{}{"state", "=", "draft"}}, values), ShouldBeTrue)
+		So(domainMatchesValues(domains.Domain{[]interface{}{"state", "!=", "draft"}}, values), ShouldBeFalse)
+		So(domainMatchesValues(domains.Domain{"|",
+			[]interface{}{"state", "=", "done"}, []interface{}{"amount", ">", float64(5)}}, values), ShouldBeTrue)
+		So(domainMatchesValues(domains.Domain{
+			[]interface{}{"state", "=", "draft"}, []interface{}{"amount", "<", float64(5)}}, values), ShouldBeFalse)
+		So(domainMatchesValues(domains.Domain{"!",
+			[]interface{}{"partner_id", "in", []interface{}{float64(3), float64(4)}}}, values), ShouldBeFalse)
+		So(domainMatchesValues(domains.Domain{[]interface{}{"note", "=", false}}, values), ShouldBeTrue)
+	})
+}
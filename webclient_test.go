@@ -4,8 +4,16 @@
 package web
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
 	"net/url"
 	"testing"
 	"time"
@@ -13,9 +21,15 @@ import (
 	"github.com/hexya-addons/web/client"
 	"github.com/hexya-addons/web/controllers"
 	"github.com/hexya-addons/web/domains"
+	"github.com/hexya-addons/web/webtypes"
+	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/security"
 	"github.com/hexya-erp/hexya/src/models/types"
 	"github.com/hexya-erp/hexya/src/server"
+	"github.com/hexya-erp/pool/h"
+	"github.com/hexya-erp/pool/q"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
 )
 
 func TestWebclientCalls(t *testing.T) {
@@ -80,5 +94,752 @@ func TestWebclientCalls(t *testing.T) {
 			So(res[0], ShouldContainKey, "id")
 			So(res[0]["id"], ShouldEqual, 1)
 		})
+		Convey("Fetching a view arch", func() {
+			raw, err := cl.RPC("/web/view/arch", "call", map[string]interface{}{
+				"view_id": "base_view_users_form_simple_modif",
+			})
+			So(err, ShouldBeNil)
+			var res webtypes.ViewArchData
+			err = json.Unmarshal(raw, &res)
+			So(err, ShouldBeNil)
+			So(res.Model, ShouldEqual, "User")
+			So(res.Arch, ShouldContainSubstring, "chatter_position")
+			So(len(res.InheritedViews), ShouldBeGreaterThan, 1)
+			So(res.InheritedViews[0].XMLID, ShouldEqual, "base_view_users_form_simple_modif")
+			So(res.InheritedViews[len(res.InheritedViews)-1].Module, ShouldEqual, "web")
+		})
+		Convey("Exporting companies to CSV", func() {
+			resp, err := cl.PostForm(hexyaURL.String()+"/web/export/csv", url.Values{
+				"data": {`{"model":"Company","fields":[{"name":"name","label":"Company Name"},
+{"name":"partner_id/name","label":"Partner"}],"ids":[1],"domain":[],"context":{"lang":"en_US"},"import_compat":false}`},
+			})
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Content-Disposition"), ShouldEqual, "attachment; filename=Company.csv")
+			records, err := csv.NewReader(resp.Body).ReadAll()
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{
+				{"Company Name", "Partner"},
+				{"Your Company", "Your Company"},
+			})
+		})
+		Convey("Exporting to XLSX", func() {
+			resp, err := cl.PostForm(hexyaURL.String()+"/web/export/xlsx", url.Values{
+				"data": {`{"model":"Company","fields":[{"name":"name","label":"Company Name"}],
+"ids":false,"domain":[["id","=",1]],"context":{"lang":"en_US"},"import_compat":false}`},
+			})
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			So(err, ShouldBeNil)
+			var sheet string
+			for _, f := range zr.File {
+				if f.Name != "xl/worksheets/sheet1.xml" {
+					continue
+				}
+				r, err := f.Open()
+				So(err, ShouldBeNil)
+				data, _ := ioutil.ReadAll(r)
+				sheet = string(data)
+			}
+			So(sheet, ShouldContainSubstring, "Company Name")
+			So(sheet, ShouldContainSubstring, "Your Company")
+		})
+		Convey("Uploading and downloading attachments", func() {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			mw.WriteField("model", "res.company")
+			mw.WriteField("id", "1")
+			w, _ := mw.CreateFormFile("ufile", "notes.txt")
+			w.Write([]byte("Some notes"))
+			w, _ = mw.CreateFormFile("ufile", "résumé.csv")
+			w.Write([]byte("a,b\n1,2\n"))
+			mw.Close()
+			resp, err := cl.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			var attachments []struct {
+				ID       int64  `json:"id"`
+				FileName string `json:"filename"`
+				MimeType string `json:"mimetype"`
+				Error    string `json:"error"`
+			}
+			So(json.NewDecoder(resp.Body).Decode(&attachments), ShouldBeNil)
+			So(attachments, ShouldHaveLength, 2)
+			So(attachments[0].Error, ShouldBeEmpty)
+			So(attachments[0].FileName, ShouldEqual, "notes.txt")
+			// uploadImage uploads a 200x100 PNG image and returns its image URL
+			uploadImage := func() string {
+				var img, body bytes.Buffer
+				So(png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 200, 100))), ShouldBeNil)
+				mw := multipart.NewWriter(&body)
+				w, _ := mw.CreateFormFile("ufile", "image.png")
+				w.Write(img.Bytes())
+				mw.Close()
+				resp, err := cl.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				var images []struct {
+					ID int64 `json:"id"`
+				}
+				So(json.NewDecoder(resp.Body).Decode(&images), ShouldBeNil)
+				So(images, ShouldHaveLength, 1)
+				return fmt.Sprintf("%s/web/image/ir.attachment/%d/datas", hexyaURL.String(), images[0].ID)
+			}
+			Convey("Downloading an attachment by ID", func() {
+				resp, err := cl.Get(fmt.Sprintf("%s/web/content/%d?download=true", hexyaURL.String(), attachments[0].ID))
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, "attachment; filename=notes.txt")
+				So(resp.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+				data, _ := ioutil.ReadAll(resp.Body)
+				So(string(data), ShouldEqual, "Some notes")
+			})
+			Convey("Non ASCII file names should be encoded", func() {
+				resp, err := cl.Get(fmt.Sprintf("%s/web/content/%d", hexyaURL.String(), attachments[1].ID))
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, "inline; filename*=utf-8''r%C3%A9sum%C3%A9.csv")
+			})
+			Convey("Downloading a binary field with saveas", func() {
+				resp, err := cl.Get(fmt.Sprintf("%s/web/binary/saveas?model=ir.attachment&id=%d&field=datas&filename_field=name",
+					hexyaURL.String(), attachments[0].ID))
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Disposition"), ShouldEqual, "attachment; filename=notes.txt")
+				for _, field := range []string{"datas", "no_such_field"} {
+					resp, err := cl.Get(fmt.Sprintf("%s/web/binary/saveas?model=ir.attachment&id=%d&field=datas&filename_field=%s",
+						hexyaURL.String(), attachments[0].ID, field))
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+				}
+			})
+			Convey("URL attachments should not redirect to other sites", func() {
+				var linkID int64
+				So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+					linkID = h.Attachment().Create(env, h.Attachment().NewData().
+						SetName("link").
+						SetType("url").
+						SetURL("https://attacker.example.com/login")).ID()
+				}), ShouldBeNil)
+				resp, err := cl.Get(fmt.Sprintf("%s/web/content/%d", hexyaURL.String(), linkID))
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+			Convey("Requesting a resized image", func() {
+				imageURL := uploadImage()
+				resp, err := cl.Get(imageURL + "/64x64")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldEqual, "image/png")
+				cfg, err := png.DecodeConfig(resp.Body)
+				So(err, ShouldBeNil)
+				So(cfg.Width, ShouldEqual, 64)
+				So(cfg.Height, ShouldEqual, 32)
+				resp2, err := cl.Get(imageURL + "?width=50&height=50&crop=true")
+				So(err, ShouldBeNil)
+				defer resp2.Body.Close()
+				cfg, err = png.DecodeConfig(resp2.Body)
+				So(err, ShouldBeNil)
+				So(cfg.Width, ShouldEqual, 50)
+				So(cfg.Height, ShouldEqual, 50)
+			})
+			Convey("Images with too many pixels should not be resized", func() {
+				viper.Set("Web.MaxImagePixels", 10000)
+				defer viper.Set("Web.MaxImagePixels", 0)
+				imageURL := uploadImage()
+				resp, err := cl.Get(imageURL + "/64x64")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+			Convey("SVG images should not be served inline", func() {
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				w, _ := mw.CreateFormFile("ufile", "logo.svg")
+				w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`))
+				mw.Close()
+				resp, err := cl.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+				So(err, ShouldBeNil)
+				var svgs []struct {
+					ID int64 `json:"id"`
+				}
+				So(json.NewDecoder(resp.Body).Decode(&svgs), ShouldBeNil)
+				resp.Body.Close()
+				So(svgs, ShouldHaveLength, 1)
+				resp, err = cl.Get(fmt.Sprintf("%s/web/image/ir.attachment/%d/datas", hexyaURL.String(), svgs[0].ID))
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Type"), ShouldStartWith, "image/svg+xml")
+				So(resp.Header.Get("Content-Disposition"), ShouldStartWith, "attachment")
+				So(resp.Header.Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+			})
+			Convey("Image responses should be cached", func() {
+				imageURL := uploadImage()
+				resp, err := cl.Get(imageURL)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				etag := resp.Header.Get("ETag")
+				So(etag, ShouldNotBeEmpty)
+				So(resp.Header.Get("Cache-Control"), ShouldEqual, "private, no-cache")
+				req, _ := http.NewRequest(http.MethodGet, imageURL, nil)
+				req.Header.Set("If-None-Match", etag)
+				resp, err = cl.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+				resp, err = cl.Get(imageURL + "/32x32?unique=1234")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.Header.Get("ETag"), ShouldNotEqual, etag)
+				So(resp.Header.Get("Cache-Control"), ShouldContainSubstring, "immutable")
+			})
+			Convey("Image URLs should only serve readable binary fields", func() {
+				for path, status := range map[string]int{
+					"/web/image/res.users/1/password":     http.StatusForbidden,
+					"/web/image/res.users/1/login":        http.StatusForbidden,
+					"/web/image/res.partner/999999/image": http.StatusNotFound,
+					"/web/image/no.such.model/1/image":    http.StatusNotFound,
+					"/web/image/res.partner/1/no_field":   http.StatusNotFound,
+				} {
+					resp, err := cl.Get(hexyaURL.String() + path)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, status)
+				}
+				anonymous := client.NewHexyaClient(hexyaURL.String())
+				resp, err := anonymous.Get(hexyaURL.String() + "/web/image/res.partner/1/image")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+				demo := client.NewHexyaClient(hexyaURL.String())
+				So(demo.Login("demo", "demo"), ShouldBeNil)
+				for path, status := range map[string]int{
+					"/web/image/res.users/1/password": http.StatusForbidden,
+					"/web/image/res.partner/1/image":  http.StatusOK,
+				} {
+					resp, err := demo.Get(hexyaURL.String() + path)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, status)
+				}
+			})
+			Convey("Missing records should not be found", func() {
+				resp, err := cl.Get(hexyaURL.String() + "/web/content/res.partner/999999/image")
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+			Convey("Uploads should be refused for unknown records or oversized bodies", func() {
+				// upload posts a file of the given size attached to the given model and returns the response body
+				upload := func(model string, size int) string {
+					var body bytes.Buffer
+					mw := multipart.NewWriter(&body)
+					mw.WriteField("model", model)
+					mw.WriteField("id", "1")
+					w, _ := mw.CreateFormFile("ufile", "data.bin")
+					w.Write(bytes.Repeat([]byte("x"), size))
+					mw.Close()
+					resp, err := cl.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+					So(err, ShouldBeNil)
+					defer resp.Body.Close()
+					data, _ := ioutil.ReadAll(resp.Body)
+					return string(data)
+				}
+				So(upload("no.such.model", 10), ShouldContainSubstring, "Unknown record")
+				viper.Set("Web.MaxUploadSize", 1024)
+				defer viper.Set("Web.MaxUploadSize", 0)
+				So(upload("res.company", 200*1024), ShouldContainSubstring, "request body too large")
+				// Without the CSRF header, the token is read from the form, which
+				// must not be parsed before the size of the body is checked.
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				w, _ := mw.CreateFormFile("ufile", "data.bin")
+				w.Write(bytes.Repeat([]byte("x"), 200*1024))
+				mw.WriteField("csrf_token", cl.CSRFToken)
+				mw.Close()
+				resp, err := cl.Client.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+			})
+			Convey("Active content should not be served inline", func() {
+				var body bytes.Buffer
+				mw := multipart.NewWriter(&body)
+				w, _ := mw.CreateFormFile("ufile", "page.html")
+				w.Write([]byte("<html><script>alert(1)</script></html>"))
+				mw.Close()
+				resp, err := cl.Post(hexyaURL.String()+"/web/binary/upload_attachment", mw.FormDataContentType(), &body)
+				So(err, ShouldBeNil)
+				var pages []struct {
+					ID int64 `json:"id"`
+				}
+				So(json.NewDecoder(resp.Body).Decode(&pages), ShouldBeNil)
+				resp.Body.Close()
+				So(pages, ShouldHaveLength, 1)
+				resp, err = cl.Get(fmt.Sprintf("%s/web/content/%d", hexyaURL.String(), pages[0].ID))
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Content-Disposition"), ShouldStartWith, "attachment")
+				So(resp.Header.Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+			})
+			Convey("Content URLs should only serve binary fields", func() {
+				for _, path := range []string{"/web/content/res.users/1/password", "/web/content/res.users/1/login"} {
+					resp, err := cl.Get(hexyaURL.String() + path)
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+				}
+			})
+		})
+		Convey("Logging in with two-factor authentication", func() {
+			demo := client.NewHexyaClient(hexyaURL.String())
+			So(demo.Login("demo", "demo"), ShouldBeNil)
+			defer models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				h.User().Search(env, q.User().Login().Equals("demo")).TOTPReset()
+			})
+			raw, err := demo.RPC("/web/session/totp/enroll", "call", nil)
+			So(err, ShouldBeNil)
+			var enrollment controllers.TOTPEnrollment
+			So(json.Unmarshal(raw, &enrollment), ShouldBeNil)
+			So(enrollment.QRCode, ShouldStartWith, "data:image/png;base64,")
+			uri, err := url.Parse(enrollment.URI)
+			So(err, ShouldBeNil)
+			secret := uri.Query().Get("secret")
+			step := time.Now().Unix() / totpPeriod
+			code, _ := totpCode(secret, step)
+			raw, err = demo.RPC("/web/session/totp/confirm", "call", controllers.TOTPConfirmParams{Code: code})
+			So(err, ShouldBeNil)
+			var recoveryCodes []string
+			So(json.Unmarshal(raw, &recoveryCodes), ShouldBeNil)
+			So(recoveryCodes, ShouldHaveLength, totpRecoveryCodesCount)
+
+			other := client.NewHexyaClient(hexyaURL.String())
+			resp, err := other.PostForm(hexyaURL.String()+"/web/login", url.Values{"login": {"demo"}, "password": {"demo"}})
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "totp_code")
+			resp, err = other.Get(hexyaURL.String() + "/web")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web/login")
+			resp, err = other.PostForm(hexyaURL.String()+"/web/login", url.Values{"login": {"demo"}, "password": {"demo"}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			resp, err = other.PostForm(hexyaURL.String()+"/web/login/totp", url.Values{"totp_code": {recoveryCodes[0]}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web")
+		})
+		Convey("Authenticating RPC calls with API keys", func() {
+			raw, err := cl.RPC("/web/session/api_key/create", "call", controllers.APIKeyCreateParams{
+				Name: "Integration", Scope: "dataset"})
+			So(err, ShouldBeNil)
+			var datasetKey string
+			So(json.Unmarshal(raw, &datasetKey), ShouldBeNil)
+			raw, err = cl.RPC("/web/session/api_key/create", "call", controllers.APIKeyCreateParams{Name: "RPC"})
+			So(err, ShouldBeNil)
+			var rpcKey string
+			So(json.Unmarshal(raw, &rpcKey), ShouldBeNil)
+			raw, err = cl.RPC("/web/session/api_key/list", "call", nil)
+			So(err, ShouldBeNil)
+			var apiKeys []controllers.APIKeyInfo
+			So(json.Unmarshal(raw, &apiKeys), ShouldBeNil)
+			So(len(apiKeys), ShouldBeGreaterThanOrEqualTo, 2)
+			defer func() {
+				for _, apiKey := range apiKeys {
+					cl.RPC("/web/session/api_key/revoke", "call", controllers.APIKeyRevokeParams{ID: apiKey.ID})
+				}
+			}()
+
+			integration := client.NewHexyaClient(hexyaURL.String())
+			integration.APIKey = datasetKey
+			raw, err = integration.RPC("/web/dataset/search_read", "call", controllers.SearchReadParams{
+				Model:  "User",
+				Domain: domains.Domain{},
+				Fields: []string{"login"},
+			})
+			So(err, ShouldBeNil)
+			var res map[string]interface{}
+			So(json.Unmarshal(raw, &res), ShouldBeNil)
+			So(res, ShouldContainKey, "records")
+			So(integration.Jar.Cookies(&hexyaURL), ShouldBeEmpty)
+			_, err = integration.RPC("/web/dataset/call_kw", "call", controllers.CallParams{
+				Model:  "User",
+				Method: "TOTPEnroll",
+				Args:   []json.RawMessage{json.RawMessage(`[1]`)},
+			})
+			So(err, ShouldNotBeNil)
+			_, err = integration.RPC("/web/dataset/call_kw", "call", controllers.CallParams{
+				Model:  "res.users",
+				Method: "write",
+				Args: []json.RawMessage{
+					json.RawMessage(`[1]`),
+					json.RawMessage(`{"email":"attacker@example.com"}`),
+				},
+			})
+			So(err, ShouldNotBeNil)
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				So(h.User().BrowseOne(env, security.SuperUserID).Email(), ShouldNotEqual, "attacker@example.com")
+			}), ShouldBeNil)
+			_, err = integration.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldNotBeNil)
+			integration.APIKey = rpcKey
+			_, err = integration.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			_, err = integration.RPC("/web/session/api_key/create", "call", controllers.APIKeyCreateParams{Name: "Other"})
+			So(err, ShouldNotBeNil)
+			_, err = integration.RPC("/web/session/api_key/list", "call", nil)
+			So(err, ShouldNotBeNil)
+			_, err = integration.RPC("/web/session/totp/enroll", "call", nil)
+			So(err, ShouldNotBeNil)
+			_, err = integration.RPC("/web/session/logout_other_devices", "call", nil)
+			So(err, ShouldNotBeNil)
+			integration.APIKey = "invalid-key"
+			_, err = integration.RPC("/web/dataset/search_read", "call", controllers.SearchReadParams{Model: "User"})
+			So(err, ShouldNotBeNil)
+
+			uid, err := security.AuthenticationRegistry.Authenticate("admin", rpcKey, types.NewContext())
+			So(err, ShouldBeNil)
+			So(uid, ShouldEqual, security.SuperUserID)
+			resp, err := client.NewHexyaClient(hexyaURL.String()).PostForm(hexyaURL.String()+"/web/login",
+				url.Values{"login": {"admin"}, "password": {rpcKey}})
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "Wrong login or password")
+		})
+		Convey("Requests without CSRF token should be refused", func() {
+			rpcBody := `{"jsonrpc":"2.0","id":1,"method":"call","params":{}}`
+			resp, err := cl.Client.Post(hexyaURL.String()+"/web/session/get_session_info", "application/json",
+				bytes.NewReader([]byte(rpcBody)))
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			req, _ := http.NewRequest(http.MethodPost, hexyaURL.String()+"/web/session/get_session_info",
+				bytes.NewReader([]byte(rpcBody)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(controllers.CSRFHeader, "wrong-token")
+			resp, err = cl.Client.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			_, err = cl.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			anonymous := client.NewHexyaClient(hexyaURL.String())
+			resp, err = anonymous.Client.PostForm(hexyaURL.String()+"/web/login",
+				url.Values{"login": {"admin"}, "password": {"admin"}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusForbidden)
+			So(anonymous.FetchCSRFToken(), ShouldBeNil)
+			So(anonymous.CSRFToken, ShouldNotBeBlank)
+			resp, err = anonymous.Client.PostForm(hexyaURL.String()+"/web/login",
+				url.Values{"login": {"admin"}, "password": {"admin"}, "csrf_token": {anonymous.CSRFToken}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Request.URL.Path, ShouldEqual, "/web")
+		})
+		Convey("Resetting a forgotten password", func() {
+			sender := new(stubPasswordResetSender)
+			RegisterPasswordResetSender(sender)
+			defer RegisterPasswordResetSender(nil)
+			defer models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				demo := h.User().Search(env, q.User().Login().Equals("demo"))
+				demo.SetPassword("demo")
+				demo.SetPasswordHistory("")
+			})
+			anonymous := client.NewHexyaClient(hexyaURL.String())
+			resp, err := anonymous.PostForm(hexyaURL.String()+"/web/reset_password", url.Values{"login": {"demo"}})
+			So(err, ShouldBeNil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "a link to reset its password has been sent")
+			link, err := url.Parse(sender.link)
+			So(err, ShouldBeNil)
+			token := link.Query().Get("token")
+			So(token, ShouldNotBeBlank)
+			resp, err = anonymous.Get(hexyaURL.String() + "/web/reset_password?token=" + url.QueryEscape(token))
+			So(err, ShouldBeNil)
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "new_password")
+			resp, err = anonymous.PostForm(hexyaURL.String()+"/web/reset_password", url.Values{
+				"token": {token}, "new_password": {"password123"}, "confirm_password": {"password123"}})
+			So(err, ShouldBeNil)
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "too easy to guess")
+			resp, err = anonymous.PostForm(hexyaURL.String()+"/web/reset_password", url.Values{
+				"token": {token}, "new_password": {"Tr0ub4dor&3x!"}, "confirm_password": {"Tr0ub4dor&3x!"}})
+			So(err, ShouldBeNil)
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			So(string(body), ShouldContainSubstring, "Your password has been changed")
+			resp, err = anonymous.PostForm(hexyaURL.String()+"/web/login", url.Values{"login": {"demo"}, "password": {"demo"}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web/login")
+			resp, err = anonymous.PostForm(hexyaURL.String()+"/web/login", url.Values{"login": {"demo"}, "password": {"Tr0ub4dor&3x!"}})
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web")
+		})
+		Convey("Login and logout should not redirect to other sites", func() {
+			viper.Set("Web.RedirectAllowedHosts", []string{"intranet.example.com"})
+			defer viper.Set("Web.RedirectAllowedHosts", nil)
+			noFollow := func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+			redirects := map[string]string{
+				"/web#action=12":                    "/web#action=12",
+				"https://intranet.example.com/wiki": "https://intranet.example.com/wiki",
+				"https://evil.example.com/":         "/web",
+				"//evil.example.com":                "/web",
+				"/\\evil.example.com":               "/web",
+				"https://intranet.example.com@evil": "/web",
+				"javascript:alert(document.cookie)": "/web",
+				"web":                               "/web",
+			}
+			Convey("Login page of logged in users", func() {
+				cl.CheckRedirect = noFollow
+				defer func() { cl.CheckRedirect = nil }()
+				for redirect, location := range redirects {
+					resp, err := cl.Get(hexyaURL.String() + "/web/login?redirect=" + url.QueryEscape(redirect))
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusSeeOther)
+					So(resp.Header.Get("Location"), ShouldEqual, location)
+				}
+			})
+			Convey("Login form", func() {
+				for redirect, location := range redirects {
+					anonymous := client.NewHexyaClient(hexyaURL.String())
+					So(anonymous.FetchCSRFToken(), ShouldBeNil)
+					anonymous.CheckRedirect = noFollow
+					resp, err := anonymous.PostForm(hexyaURL.String()+"/web/login",
+						url.Values{"login": {"admin"}, "password": {"admin"}, "redirect": {redirect}})
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusSeeOther)
+					So(resp.Header.Get("Location"), ShouldEqual, location)
+				}
+			})
+			Convey("Logout", func() {
+				for redirect, location := range redirects {
+					other := client.NewHexyaClient(hexyaURL.String())
+					So(other.Login("admin", "admin"), ShouldBeNil)
+					other.CheckRedirect = noFollow
+					resp, err := other.Get(hexyaURL.String() + "/web/session/logout?redirect=" + url.QueryEscape(redirect))
+					So(err, ShouldBeNil)
+					resp.Body.Close()
+					So(resp.StatusCode, ShouldEqual, http.StatusSeeOther)
+					So(resp.Header.Get("Location"), ShouldEqual, location)
+				}
+			})
+		})
+		Convey("Loading files through the proxy", func() {
+			// load returns the loaded file, or an empty string if the proxy returned an error
+			load := func(path string) string {
+				raw, err := cl.RPC("/web/proxy/load", "call", map[string]interface{}{"path": path})
+				So(err, ShouldBeNil)
+				var res string
+				json.Unmarshal(raw, &res)
+				return res
+			}
+			So(load("/static/web/src/js/boot.js"), ShouldContainSubstring, "Hexya Web Boostrap Code")
+			for _, path := range []string{
+				"http://evil.example.com/static/web/src/js/boot.js",
+				"//evil.example.com/static/web/src/js/boot.js",
+				"/static/../web/session/get_session_info",
+				"/web/session/logout",
+				"/static/web/src/js/missing.js",
+				"",
+			} {
+				So(load(path), ShouldBeBlank)
+			}
+			viper.Set("Web.ProxyMaxSize", 10)
+			defer viper.Set("Web.ProxyMaxSize", 0)
+			So(load("/static/web/src/js/boot.js"), ShouldBeBlank)
+		})
+		Convey("Switching companies", func() {
+			var branchID int64
+			models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				branch := h.Company().Create(env, h.Company().NewData().SetName("Branch"))
+				branchID = branch.ID()
+				admin := h.User().Search(env, q.User().Login().Equals("admin"))
+				admin.SetCompanies(admin.Companies().Union(branch))
+			})
+			defer models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				admin := h.User().Search(env, q.User().Login().Equals("admin"))
+				admin.SetCompanies(admin.Companies().Subtract(h.Company().BrowseOne(env, branchID)))
+			})
+			defer cl.RPC("/web/session/switch_company", "call", controllers.SwitchCompanyParams{CompanyID: 1})
+			// activeCompanies returns the active companies of a model call with the given context
+			activeCompanies := func(context string) []int64 {
+				raw, err := cl.RPC("/web/dataset/call_kw", "call", controllers.CallParams{
+					Model:  "User",
+					Method: "active_companies",
+					KWArgs: map[string]json.RawMessage{"context": json.RawMessage(context)},
+				})
+				So(err, ShouldBeNil)
+				var res interface{}
+				So(json.Unmarshal(raw, &res), ShouldBeNil)
+				switch ids := res.(type) {
+				case float64:
+					return []int64{int64(ids)}
+				case []interface{}:
+					result := make([]int64, len(ids))
+					for i, id := range ids {
+						result[i] = int64(id.(float64))
+					}
+					return result
+				}
+				return nil
+			}
+			var info controllers.SessionInfo
+			raw, err := cl.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(raw, &info), ShouldBeNil)
+			So(info.DisplaySwitchCompanyMenu, ShouldBeTrue)
+			So(info.CompanyID, ShouldEqual, 1)
+			So(len(info.UserCompanies.AllowedCompanies), ShouldBeGreaterThanOrEqualTo, 2)
+
+			So(activeCompanies(`{}`), ShouldResemble, []int64{1})
+			So(activeCompanies(fmt.Sprintf(`{"allowed_company_ids":[%d]}`, branchID)), ShouldResemble, []int64{branchID})
+			So(activeCompanies(`{"allowed_company_ids":[987654]}`), ShouldResemble, []int64{1})
+
+			raw, err = cl.RPC("/web/session/switch_company", "call", controllers.SwitchCompanyParams{
+				CompanyID: branchID, AllowedCompanyIDs: []int64{1, 987654}})
+			So(err, ShouldBeNil)
+			var companies controllers.UserCompanies
+			So(json.Unmarshal(raw, &companies), ShouldBeNil)
+			So(companies.CurrentCompany.ID, ShouldEqual, branchID)
+			So(companies.AllowedCompanies, ShouldHaveLength, 2)
+			raw, err = cl.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(raw, &info), ShouldBeNil)
+			So(info.CompanyID, ShouldEqual, branchID)
+			So(info.UserContext["allowed_company_ids"], ShouldResemble, []interface{}{float64(branchID), float64(1)})
+			So(activeCompanies(`{}`), ShouldHaveLength, 2)
+			So(activeCompanies(`{"allowed_company_ids":[1]}`), ShouldResemble, []int64{1})
+		})
+		Convey("Cache hashes should identify the content of the cached routes", func() {
+			var info controllers.SessionInfo
+			raw, err := cl.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(raw, &info), ShouldBeNil)
+			for _, key := range []string{"load_menus", "qweb", "translations"} {
+				So(info.CacheHashes[key], ShouldHaveLength, 40)
+			}
+			var info2 controllers.SessionInfo
+			raw, err = cl.RPC("/web/session/get_session_info", "call", nil)
+			So(err, ShouldBeNil)
+			So(json.Unmarshal(raw, &info2), ShouldBeNil)
+			So(info2.CacheHashes, ShouldResemble, info.CacheHashes)
+			lang := info.UserContext["lang"].(string)
+			for route, hash := range map[string]string{
+				"/web/webclient/load_menus/%s":                         info.CacheHashes["load_menus"],
+				"/web/webclient/qweb/%s?mods=web":                      info.CacheHashes["qweb"],
+				"/web/webclient/translations/%s?mods=web&lang=" + lang: info.CacheHashes["translations"],
+			} {
+				resp, err := cl.Get(hexyaURL.String() + fmt.Sprintf(route, hash))
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				So(resp.Header.Get("Cache-Control"), ShouldContainSubstring, "immutable")
+				etag := resp.Header.Get("ETag")
+				So(etag, ShouldNotBeEmpty)
+				req, _ := http.NewRequest(http.MethodGet, hexyaURL.String()+fmt.Sprintf(route, "1234"), nil)
+				req.Header.Set("If-None-Match", etag)
+				resp, err = cl.Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusNotModified)
+				So(resp.Header.Get("Cache-Control"), ShouldEqual, "private, no-cache")
+			}
+		})
+		Convey("Repeated failed logins should be throttled", func() {
+			attacker := client.NewHexyaClient(hexyaURL.String())
+			vals := url.Values{"login": {"brute-force@example.com"}, "password": {"wrong"}}
+			for i := 0; i < 5; i++ {
+				resp, err := attacker.PostForm(hexyaURL.String()+"/web/login", vals)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			}
+			resp, err := attacker.PostForm(hexyaURL.String()+"/web/login", vals)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		})
+		Convey("Expired sessions", func() {
+			anonymous := client.NewHexyaClient(hexyaURL.String())
+			Convey("HTML pages should redirect to the login page with the requested URL", func() {
+				resp, err := anonymous.Get(hexyaURL.String() + "/web?debug=1")
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.Request.URL.Path, ShouldEqual, "/web/login")
+				So(resp.Request.URL.Query().Get("redirect"), ShouldEqual, "/web?debug=1")
+			})
+			Convey("RPC calls should get a session expired error", func() {
+				So(anonymous.Login("admin", "admin"), ShouldBeNil)
+				viper.Set("Web.SessionLifetime", time.Nanosecond)
+				defer viper.Set("Web.SessionLifetime", 0)
+				resp, err := anonymous.Post(hexyaURL.String()+"/web/session/get_session_info", "application/json",
+					bytes.NewReader([]byte(`{"jsonrpc":"2.0","id":7,"method":"call","params":{}}`)))
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+				var res struct {
+					ID    int64
+					Error struct {
+						Code int
+						Data struct {
+							Name string
+						}
+					}
+				}
+				So(json.NewDecoder(resp.Body).Decode(&res), ShouldBeNil)
+				So(res.ID, ShouldEqual, 7)
+				So(res.Error.Code, ShouldEqual, 100)
+				So(res.Error.Data.Name, ShouldEqual, "hexya.http.SessionExpiredException")
+			})
+		})
+		Convey("Logging out other devices", func() {
+			other := client.NewHexyaClient(hexyaURL.String())
+			So(other.Login("admin", "admin"), ShouldBeNil)
+			resp, err := other.Get(hexyaURL.String() + "/web")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web")
+			raw, err := cl.RPC("/web/session/logout_other_devices", "call", nil)
+			So(err, ShouldBeNil)
+			var count int
+			So(json.Unmarshal(raw, &count), ShouldBeNil)
+			So(count, ShouldBeGreaterThanOrEqualTo, 1)
+			resp, err = other.Get(hexyaURL.String() + "/web")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web/login")
+			resp, err = cl.Get(hexyaURL.String() + "/web")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.Request.URL.Path, ShouldEqual, "/web")
+		})
 	})
 }
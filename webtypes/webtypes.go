@@ -6,10 +6,12 @@ package webtypes
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/hexya-addons/web/domains"
 	"github.com/hexya-erp/hexya/src/actions"
 	"github.com/hexya-erp/hexya/src/models"
+	"github.com/hexya-erp/hexya/src/models/fieldtype"
 	"github.com/hexya-erp/hexya/src/models/operator"
 	"github.com/hexya-erp/hexya/src/tools/nbutils"
 	"github.com/hexya-erp/hexya/src/views"
@@ -32,6 +34,28 @@ type FieldsViewData struct {
 	Fields      map[string]*models.FieldInfo `json:"fields"`
 	Toolbar     Toolbar                      `json:"toolbar"`
 	FieldParent string                       `json:"field_parent"`
+	// RawArch and InheritedViews are only set in debug mode
+	RawArch        string          `json:"raw_arch,omitempty"`
+	InheritedViews []InheritedView `json:"inherited_views,omitempty"`
+}
+
+// An InheritedView is a view definition that contributes to the arch
+// of a view, either as the base view or as an extension of it.
+type InheritedView struct {
+	XMLID     string `json:"xml_id"`
+	InheritID string `json:"inherit_id"`
+	Module    string `json:"module"`
+	File      string `json:"file"`
+}
+
+// ViewArchData is the return type of the GetViewArch method
+type ViewArchData struct {
+	ID             string          `json:"id"`
+	Name           string          `json:"name"`
+	Model          string          `json:"model"`
+	Type           views.ViewType  `json:"type"`
+	Arch           string          `json:"arch"`
+	InheritedViews []InheritedView `json:"inherited_views"`
 }
 
 // SubViewData is the type expected for Views in FieldsViewData
@@ -147,9 +171,48 @@ type LoadViewsArgs struct {
 
 // LoadViewsOptions are options that can be passed to LoadViews method
 type LoadViewsOptions struct {
-	Toolbar     bool   `json:"toolbar"`
-	LoadFilters bool   `json:"load_filters"`
-	ActionID    string `json:"action_id"`
+	Toolbar     bool     `json:"toolbar"`
+	LoadFilters bool     `json:"load_filters"`
+	ActionID    ActionID `json:"action_id"`
+}
+
+// An ActionID is a reference to an action of the actions registry.
+//
+// It can be unmarshalled from the action's integer ID (possibly
+// given as a string) or from the action's XML ID. A false or null
+// value is unmarshalled as 0, meaning no action.
+type ActionID int64
+
+// UnmarshalJSON for ActionID type
+func (aid *ActionID) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil, bool:
+		*aid = 0
+		return nil
+	case float64:
+		*aid = ActionID(v)
+		return nil
+	case string:
+		if v == "" {
+			*aid = 0
+			return nil
+		}
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			*aid = ActionID(id)
+			return nil
+		}
+		action, ok := actions.Registry.GetByXMLID(v)
+		if !ok {
+			return fmt.Errorf("unknown action: %s", v)
+		}
+		*aid = ActionID(action.ID)
+		return nil
+	}
+	return fmt.Errorf("unable to unmarshal ActionID: %s", string(data))
 }
 
 // LoadViewsData is the result struct of the LoadViews method
@@ -171,3 +234,70 @@ type OnChangeResult struct {
 	Warning string                   `json:"warning"`
 	Filters map[string][]interface{} `json:"domain"`
 }
+
+// SearchPanelParams is the args struct for the SearchPanelSelectRange
+// and SearchPanelSelectMultiRange methods
+type SearchPanelParams struct {
+	FieldName       string         `json:"field_name"`
+	CategoryDomain  domains.Domain `json:"category_domain"`
+	ComodelDomain   domains.Domain `json:"comodel_domain"`
+	FilterDomain    domains.Domain `json:"filter_domain"`
+	SearchDomain    domains.Domain `json:"search_domain"`
+	DisableCounters bool           `json:"disable_counters"`
+	GroupBy         string         `json:"group_by"`
+}
+
+// SearchPanelRangeResult is the result struct of the SearchPanelSelectRange method
+type SearchPanelRangeResult struct {
+	ParentField string            `json:"parent_field"`
+	Values      []models.FieldMap `json:"values"`
+}
+
+// ImportOptions are the options for parsing a file to import
+type ImportOptions struct {
+	Headers                bool   `json:"headers"`
+	Separator              string `json:"separator"`
+	FloatThousandSeparator string `json:"float_thousand_separator"`
+	FloatDecimalSeparator  string `json:"float_decimal_separator"`
+	Limit                  int    `json:"limit"`
+}
+
+// ImportField is a field that can be imported, with its importable sub fields
+type ImportField struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	String   string         `json:"string"`
+	Required bool           `json:"required"`
+	Type     fieldtype.Type `json:"type"`
+	Fields   []ImportField  `json:"fields"`
+}
+
+// ImportPreview is the result struct of the ParsePreview method
+type ImportPreview struct {
+	Fields  []ImportField  `json:"fields"`
+	Headers []string       `json:"headers"`
+	Matches map[int]string `json:"matches"`
+	Preview [][]string     `json:"preview"`
+	Options ImportOptions  `json:"options"`
+}
+
+// ImportParams is the args struct for the Do method of the import wizard
+type ImportParams struct {
+	Fields  []string      `json:"fields"`
+	Options ImportOptions `json:"options"`
+	DryRun  bool          `json:"dryrun"`
+}
+
+// ImportMessage reports an error or a warning on a row of an imported file
+type ImportMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+}
+
+// ImportResult is the result struct of the Do method of the import wizard
+type ImportResult struct {
+	IDs      []int64         `json:"ids"`
+	Messages []ImportMessage `json:"messages"`
+}